@@ -12,11 +12,13 @@ environment =
     DAFFY_BASE_URL="__DAFFY_BASE_URL__",
     DAFFY_TWITTER_CONSUMER_KEY="__DAFFY_TWITTER_CONSUMER_KEY__",
     DAFFY_TWITTER_CONSUMER_SECRET="__DAFFY_TWITTER_CONSUMER_SECRET__",
-    DAFFY_GPLUS_CLIENT_ID="__DAFFY_GPLUS_CLIENT_ID__",
-    DAFFY_GPLUS_CLIENT_SECRET="__DAFFY_GPLUS_CLIENT_SECRET__",
+    DAFFY_GOOGLE_CLIENT_ID="__DAFFY_GOOGLE_CLIENT_ID__",
+    DAFFY_GOOGLE_CLIENT_SECRET="__DAFFY_GOOGLE_CLIENT_SECRET__",
     DAFFY_GITHUB_CLIENT_ID="__DAFFY_GITHUB_CLIENT_ID__",
     DAFFY_GITHUB_CLIENT_SECRET="__DAFFY_GITHUB_CLIENT_SECRET__",
     DAFFY_SESSION_AUTH_KEY_V2="__DAFFY_SESSION_AUTH_KEY_V2__",
     DAFFY_SESSION_ENC_KEY_V2="__DAFFY_SESSION_ENC_KEY_V2__",
     DAFFY_SESSION_AUTH_KEY_V1="__DAFFY_SESSION_AUTH_KEY_V1__",
-    DAFFY_SESSION_ENC_KEY_V1=__DAFFY_SESSION_ENC_KEY_V1__""
+    DAFFY_SESSION_ENC_KEY_V1="__DAFFY_SESSION_ENC_KEY_V1__",
+    DAFFY_TOKEN_ENC_KEY_V2="__DAFFY_TOKEN_ENC_KEY_V2__",
+    DAFFY_TOKEN_ENC_KEY_V1="__DAFFY_TOKEN_ENC_KEY_V1__"
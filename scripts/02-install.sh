@@ -23,8 +23,8 @@ DAFFY_BASE_URL=`ask.sh daffy DAFFY_BASE_URL 'What is the base URL (e.g. http://l
 # Social Providers
 DAFFY_TWITTER_CONSUMER_KEY=`ask.sh daffy DAFFY_TWITTER_CONSUMER_KEY 'Enter your Twitter Consumer Key :'`
 DAFFY_TWITTER_CONSUMER_SECRET=`ask.sh daffy DAFFY_TWITTER_CONSUMER_SECRET 'Enter your Twitter Consumer Secret :'`
-DAFFY_GPLUS_CLIENT_ID=`ask.sh daffy DAFFY_GPLUS_CLIENT_ID 'Enter your GPlus Client Id :'`
-DAFFY_GPLUS_CLIENT_SECRET=`ask.sh daffy DAFFY_GPLUS_CLIENT_SECRET 'Enter your GPlus Client Secret :'`
+DAFFY_GOOGLE_CLIENT_ID=`ask.sh daffy DAFFY_GOOGLE_CLIENT_ID 'Enter your Google Client Id :'`
+DAFFY_GOOGLE_CLIENT_SECRET=`ask.sh daffy DAFFY_GOOGLE_CLIENT_SECRET 'Enter your Google Client Secret :'`
 DAFFY_GITHUB_CLIENT_ID=`ask.sh daffy DAFFY_GITHUB_CLIENT_ID 'Enter your GitHub Client Id :'`
 DAFFY_GITHUB_CLIENT_SECRET=`ask.sh daffy DAFFY_GITHUB_CLIENT_SECRET 'Enter your GitHub Client Secret :'`
 
@@ -34,6 +34,10 @@ DAFFY_SESSION_ENC_KEY_V2=`ask.sh daffy DAFFY_SESSION_ENC_KEY_V2 'Enter your SESS
 DAFFY_SESSION_AUTH_KEY_V1=`ask.sh daffy DAFFY_SESSION_AUTH_KEY_V1 'Enter your SESSION_AUTH_KEY_V1 :'`
 DAFFY_SESSION_ENC_KEY_V1=`ask.sh daffy DAFFY_SESSION_ENC_KEY_V1 'Enter your SESSION_ENC_KEY_V1 :'`
 
+# Token Encryption
+DAFFY_TOKEN_ENC_KEY_V2=`ask.sh daffy DAFFY_TOKEN_ENC_KEY_V2 'Enter your TOKEN_ENC_KEY_V2 :'`
+DAFFY_TOKEN_ENC_KEY_V1=`ask.sh daffy DAFFY_TOKEN_ENC_KEY_V1 'Enter your TOKEN_ENC_KEY_V1 :'`
+
 echo "Building code ..."
 gb build
 echo
@@ -46,14 +50,16 @@ m4 \
     -D __DAFFY_BASE_URL__=$DAFFY_BASE_URL \
     -D __DAFFY_TWITTER_CONSUMER_KEY__=$DAFFY_TWITTER_CONSUMER_KEY \
     -D __DAFFY_TWITTER_CONSUMER_SECRET__=$DAFFY_TWITTER_CONSUMER_SECRET \
-    -D __DAFFY_GPLUS_CLIENT_ID__=$DAFFY_GPLUS_CLIENT_ID \
-    -D __DAFFY_GPLUS_CLIENT_SECRET__=$DAFFY_GPLUS_CLIENT_SECRET \
+    -D __DAFFY_GOOGLE_CLIENT_ID__=$DAFFY_GOOGLE_CLIENT_ID \
+    -D __DAFFY_GOOGLE_CLIENT_SECRET__=$DAFFY_GOOGLE_CLIENT_SECRET \
     -D __DAFFY_GITHUB_CLIENT_ID__=$DAFFY_GITHUB_CLIENT_ID \
     -D __DAFFY_GITHUB_CLIENT_SECRET__=$DAFFY_GITHUB_CLIENT_SECRET \
     -D __DAFFY_SESSION_AUTH_KEY_V2__=$DAFFY_SESSION_AUTH_KEY_V2 \
     -D __DAFFY_SESSION_ENC_KEY_V2__=$DAFFY_SESSION_ENC_KEY_V2 \
     -D __DAFFY_SESSION_AUTH_KEY_V1__=$DAFFY_SESSION_AUTH_KEY_V1 \
     -D __DAFFY_SESSION_ENC_KEY_V1__=$DAFFY_SESSION_ENC_KEY_V1 \
+    -D __DAFFY_TOKEN_ENC_KEY_V2__=$DAFFY_TOKEN_ENC_KEY_V2 \
+    -D __DAFFY_TOKEN_ENC_KEY_V1__=$DAFFY_TOKEN_ENC_KEY_V1 \
     etc/supervisor/conf.d/io-daffy.conf.m4 | sudo tee /etc/supervisor/conf.d/io-daffy.conf
 echo
 
@@ -70,14 +76,16 @@ m4 \
     -D __DAFFY_BASE_URL__=$DAFFY_BASE_URL \
     -D __DAFFY_TWITTER_CONSUMER_KEY__=$DAFFY_TWITTER_CONSUMER_KEY \
     -D __DAFFY_TWITTER_CONSUMER_SECRET__=$DAFFY_TWITTER_CONSUMER_SECRET \
-    -D __DAFFY_GPLUS_CLIENT_ID__=$DAFFY_GPLUS_CLIENT_ID \
-    -D __DAFFY_GPLUS_CLIENT_SECRET__=$DAFFY_GPLUS_CLIENT_SECRET \
+    -D __DAFFY_GOOGLE_CLIENT_ID__=$DAFFY_GOOGLE_CLIENT_ID \
+    -D __DAFFY_GOOGLE_CLIENT_SECRET__=$DAFFY_GOOGLE_CLIENT_SECRET \
     -D __DAFFY_GITHUB_CLIENT_ID__=$DAFFY_GITHUB_CLIENT_ID \
     -D __DAFFY_GITHUB_CLIENT_SECRET__=$DAFFY_GITHUB_CLIENT_SECRET \
     -D __DAFFY_SESSION_AUTH_KEY_V2__=$DAFFY_SESSION_AUTH_KEY_V2 \
     -D __DAFFY_SESSION_ENC_KEY_V2__=$DAFFY_SESSION_ENC_KEY_V2 \
     -D __DAFFY_SESSION_AUTH_KEY_V1__=$DAFFY_SESSION_AUTH_KEY_V1 \
     -D __DAFFY_SESSION_ENC_KEY_V1__=$DAFFY_SESSION_ENC_KEY_V1 \
+    -D __DAFFY_TOKEN_ENC_KEY_V2__=$DAFFY_TOKEN_ENC_KEY_V2 \
+    -D __DAFFY_TOKEN_ENC_KEY_V1__=$DAFFY_TOKEN_ENC_KEY_V1 \
     etc/caddy/vhosts/io.daffy.conf.m4 | sudo tee /etc/caddy/vhosts/io.daffy.conf
 echo
 
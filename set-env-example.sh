@@ -11,6 +11,16 @@
  export DAFFY_BASE_URL=https://example.com
  export DAFFY_PORT=8080
  export DAFFY_DB_DUMP_DIR=/var/lib/daffy/db
+ # how many hourly, daily and weekly dumps to keep (these are the defaults, 0 for all three keeps everything)
+ export DAFFY_DB_DUMP_KEEP_HOURLY=24
+ export DAFFY_DB_DUMP_KEEP_DAILY=7
+ export DAFFY_DB_DUMP_KEEP_WEEKLY=4
+ # optional, set to `true` to show the real errors on error pages (never in production)
+ export DAFFY_DEV_MODE=
+ # optional, set to `true` to let ANYONE log in as ANY user with the `dev` provider (never in production)
+ export DAFFY_DEV_LOGIN=
+ # optional, e.g. 168h - how long deleted accounts are kept (hidden) before being purged (default is immediately)
+ export DAFFY_ACCOUNT_DELETE_GRACE_PERIOD=
 
  # --- OAuth ---
 
@@ -21,16 +31,13 @@
  export DAFFY_TWITTER_CONSUMER_KEY=
  export DAFFY_TWITTER_CONSUMER_SECRET=
 
- # Google (Note: we say Google, but Goth uses `gplus` instead):
+ # Google (via OpenID Connect, which replaced the old `gplus` provider, see the ReadMe):
  #
- # * See : https://developers.google.com/identity/sign-in/web/devconsole-project
+ # * See : https://developers.google.com/identity/protocols/oauth2/openid-connect
  # * See : https://support.google.com/cloud/answer/6158849?hl=en
  #
- # Goth has a `gplus` provider and not a `google`, however, I wonder if that is actually deprecated and will stop
- # working eventually. Perhaps we don't care since it works the same, but just ends up a `gplus` prefix with each
- # social entity, instead of a `google` one. ¯\_(ツ)_/¯
- export DAFFY_GPLUS_CLIENT_ID=
- export DAFFY_GPLUS_CLIENT_SECRET=
+ export DAFFY_GOOGLE_CLIENT_ID=
+ export DAFFY_GOOGLE_CLIENT_SECRET=
 
  # GitHub:
  #
@@ -40,6 +47,14 @@
  export DAFFY_GITHUB_CLIENT_ID=
  export DAFFY_GITHUB_CLIENT_SECRET=
 
+ # --- Token Encryption ---
+
+ # Encrypts the OAuth tokens stored in the `social` bucket (and therefore in the DB dumps). To rotate, add a new key,
+ # run `./bin/server db rekey`, then remove the old one. Generated with `pwgen -s 32 1`. The server won't start
+ # without the V2 key unless DAFFY_DEV_MODE is `true`.
+ export DAFFY_TOKEN_ENC_KEY_V2=dXNiRQ8l0QcPbU2lVG1mKs3Ro5SYb4Rk
+ export DAFFY_TOKEN_ENC_KEY_V1=
+
  # --- Sessions ---
 
  # generated with `pwgen -s 32 1`
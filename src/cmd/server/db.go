@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+
+	"internal/store"
+)
+
+// dbFilename is where the BoltDB datastore lives, relative to the working directory.
+const dbFilename = "daffy.db"
+
+var errUnknownDbCommand = errors.New("usage: server db <migrate|rekey|restore|export|import|check>")
+
+// newBoltStore creates (but doesn't open) the BoltStore, with the token keyring set up from the environment.
+//
+// To create newer token keys, do the same as the session keys: add a V3 environment variable, keep the V2 (and V1 if
+// still set) around, run `server db rekey`, then drop the old ones.
+func newBoltStore() (*store.BoltStore, error) {
+	keyring, err := store.NewKeyring(
+		// New Key
+		[]byte(os.Getenv("DAFFY_TOKEN_ENC_KEY_V2")),
+		// Old Key
+		[]byte(os.Getenv("DAFFY_TOKEN_ENC_KEY_V1")),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if !keyring.Enabled() {
+		log.Println("No DAFFY_TOKEN_ENC_KEY_V* specified - OAuth tokens will be stored in plaintext")
+	}
+
+	boltStore := store.NewBoltStore(dbFilename)
+	boltStore.SetKeyring(keyring)
+	return boltStore, nil
+}
+
+// dbCommand runs one of the `server db ...` maintenance commands. These open the datastore themselves, so the server
+// must not be running at the same time.
+func dbCommand(args []string) error {
+	if len(args) == 0 {
+		return errUnknownDbCommand
+	}
+
+	switch args[0] {
+	case "migrate":
+		return dbMigrate(args[1:])
+	case "rekey":
+		return dbRekey(args[1:])
+	case "restore":
+		return dbRestore(args[1:])
+	case "export":
+		return dbExport(args[1:])
+	case "import":
+		return dbImport(args[1:])
+	case "check":
+		return dbCheck(args[1:])
+	}
+
+	return errUnknownDbCommand
+}
+
+// dbMigrate runs any pending migrations, or with `--dry-run` just checks they would work.
+func dbMigrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "run the migrations but roll them back")
+	flags.Parse(args)
+
+	boltStore, err := newBoltStore()
+	if err != nil {
+		return err
+	}
+	err = boltStore.OpenNoMigrate()
+	if err != nil {
+		return err
+	}
+	defer boltStore.Close()
+
+	version, err := boltStore.GetSchemaVersion()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Datastore is at version %d, latest is %d\n", version, store.SchemaVersion())
+
+	applied, err := boltStore.Migrate(*dryRun)
+	for _, name := range applied {
+		if *dryRun {
+			fmt.Printf("* would apply %s\n", name)
+		} else {
+			fmt.Printf("* applied %s\n", name)
+		}
+	}
+	return err
+}
+
+// dbRekey re-encrypts every social's tokens with the newest key.
+func dbRekey(args []string) error {
+	boltStore, err := newBoltStore()
+	if err != nil {
+		return err
+	}
+	err = boltStore.Open()
+	if err != nil {
+		return err
+	}
+	defer boltStore.Close()
+
+	count, err := boltStore.ReEncryptSocials()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Re-encrypted %d socials\n", count)
+	return nil
+}
+
+// dbRestore replaces the datastore with the (gzipped) dump given.
+func dbRestore(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: server db restore <file.db.gz>")
+	}
+	return restoreDump(args[0], dbFilename)
+}
+
+// restoreDump replaces the datastore at dbFile with the (gzipped) dump. The dump is decompressed and verified first, and
+// the current datastore is kept alongside as a backup.
+func restoreDump(filename, dbFile string) error {
+	now := time.Now().Format(dumpTimeFormat)
+
+	// decompress next to the datastore, so the final rename is atomic
+	tmpFile := dbFile + ".restore-" + now
+	err := decompressDump(filename, tmpFile)
+	if err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+
+	err = store.VerifyBoltFile(tmpFile)
+	if err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+	fmt.Printf("Verified %s\n", filename)
+
+	// make sure nothing (e.g. the server) has the current datastore open
+	if _, err := os.Stat(dbFile); err == nil {
+		db, err := bolt.Open(dbFile, 0600, &bolt.Options{Timeout: 1 * time.Second})
+		if err != nil {
+			os.Remove(tmpFile)
+			return fmt.Errorf("unable to lock %s, is the server still running? (%s)", dbFile, err)
+		}
+		db.Close()
+
+		backup := dbFile + "." + now + ".bak"
+		err = os.Rename(dbFile, backup)
+		if err != nil {
+			os.Remove(tmpFile)
+			return err
+		}
+		fmt.Printf("Moved the current datastore to %s\n", backup)
+	}
+
+	err = os.Rename(tmpFile, dbFile)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Restored %s to %s\n", filename, dbFile)
+	return nil
+}
+
+// dbExport writes the whole datastore out as newline-delimited JSON, to stdout or the file given with `-o`.
+func dbExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	output := flags.String("o", "", "write to this file instead of stdout")
+	flags.Parse(args)
+
+	boltStore, err := newBoltStore()
+	if err != nil {
+		return err
+	}
+	err = boltStore.Open()
+	if err != nil {
+		return err
+	}
+	defer boltStore.Close()
+
+	if *output == "" {
+		return boltStore.Export(os.Stdout)
+	}
+
+	f, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	err = boltStore.Export(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// dbImport loads an export (from a file, or stdin if "-") into an empty datastore.
+func dbImport(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: server db import <file.ndjson|->")
+	}
+
+	in := os.Stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	boltStore, err := newBoltStore()
+	if err != nil {
+		return err
+	}
+	err = boltStore.Open()
+	if err != nil {
+		return err
+	}
+	defer boltStore.Close()
+
+	counts, err := boltStore.Import(in)
+	for recordType, count := range counts {
+		fmt.Printf("* imported %d %s records\n", count, recordType)
+	}
+	return err
+}
+
+// dbCheck looks for users, socials and index entries which don't agree with each other, and with `--repair` fixes what
+// it can. It fails if anything is left unrepaired, so it can be used from scripts.
+func dbCheck(args []string) error {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	asJson := flags.Bool("json", false, "output the problems as JSON")
+	repair := flags.Bool("repair", false, "fix the problems which can be fixed safely")
+	flags.Parse(args)
+
+	boltStore, err := newBoltStore()
+	if err != nil {
+		return err
+	}
+	err = boltStore.Open()
+	if err != nil {
+		return err
+	}
+	defer boltStore.Close()
+
+	problems, err := boltStore.Check(*repair)
+	if err != nil {
+		return err
+	}
+
+	unrepaired := 0
+	for _, problem := range problems {
+		if !problem.Repaired {
+			unrepaired++
+		}
+	}
+
+	if *asJson {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(problems)
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, problem := range problems {
+			if problem.Repaired {
+				fmt.Printf("* %s (repaired)\n", problem)
+			} else {
+				fmt.Printf("* %s\n", problem)
+			}
+		}
+		fmt.Printf("Found %d problems, %d repaired\n", len(problems), len(problems)-unrepaired)
+	}
+
+	if unrepaired > 0 {
+		return fmt.Errorf("%d problems need looking at", unrepaired)
+	}
+	return nil
+}
@@ -3,32 +3,207 @@ package main
 import (
 	"compress/gzip"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"path"
+	"sort"
+	"strings"
 	"time"
 
-	"github.com/boltdb/bolt"
+	"internal/store"
 )
 
-func dump(dir string, db *bolt.DB) error {
-	filename := path.Join(dir, time.Now().Format("20060102-150405")+".db.gz")
-	fmt.Printf("filename=%s\n", filename)
+const dumpTimeFormat = "20060102-150405"
+const dumpSuffix = ".db.gz"
 
-	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0700)
+// retention says how many dumps to keep. We keep the newest dump in each of the last `hourly` hours, `daily` days and
+// `weekly` weeks, and remove the rest. If all are zero, nothing is ever removed.
+type retention struct {
+	hourly int
+	daily  int
+	weekly int
+}
+
+func dump(dir string, api store.Api) (string, error) {
+	filename := path.Join(dir, time.Now().Format(dumpTimeFormat)+dumpSuffix)
+
+	// never overwrite an existing dump, and only we need to be able to read it
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer f.Close()
 
 	// gzip the output
 	zw := gzip.NewWriter(f)
-	defer zw.Close()
 
-	err = db.View(func(tx *bolt.Tx) error {
-		n, err := tx.WriteTo(zw)
-		log.Printf("DB Dump written %d bytes\n", n)
+	n, err := api.Dump(zw)
+	if err != nil {
+		zw.Close()
+		return filename, err
+	}
+	log.Printf("DB Dump written %d bytes\n", n)
+
+	// make sure everything is flushed before anyone reads it back
+	err = zw.Close()
+	if err != nil {
+		return filename, err
+	}
+	return filename, f.Sync()
+}
+
+// dumpAndPrune dumps the store, checks the dump can be read back, then removes any old dumps the retention policy says
+// we no longer need. A dump which can't be written or verified is removed straight away, so it is never kept in place
+// of a good one.
+func dumpAndPrune(dir string, api store.Api, keep retention) {
+	filename, err := dump(dir, api)
+	if err != nil {
+		log.Printf("Error dumping the DB: %s\n", err)
+		if filename != "" {
+			os.Remove(filename)
+		}
+		return
+	}
+
+	err = verifyDump(filename)
+	if err != nil {
+		log.Printf("Error verifying DB dump %s: %s\n", filename, err)
+		os.Remove(filename)
+		return
+	}
+	log.Printf("DB Dump %s verified\n", filename)
+
+	removed, err := pruneDumps(dir, keep, time.Now())
+	if err != nil {
+		log.Printf("Error pruning DB dumps: %s\n", err)
+	}
+	for _, name := range removed {
+		log.Printf("Removed old DB dump %s\n", name)
+	}
+}
+
+// decompressDump gunzips the dump into a new file at dst.
+func decompressDump(filename, dst string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
 		return err
+	}
+
+	_, err = io.Copy(out, zr)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	err = out.Sync()
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// verifyDump decompresses the dump to a temporary file and checks it opens as a valid datastore.
+func verifyDump(filename string) error {
+	tmpDir, err := ioutil.TempDir("", "daffy-verify-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tmpFile := path.Join(tmpDir, "daffy.db")
+	err = decompressDump(filename, tmpFile)
+	if err != nil {
+		return err
+	}
+
+	return store.VerifyBoltFile(tmpFile)
+}
+
+// pruneDumps removes the dumps in dir which the retention policy doesn't want to keep, and returns their names. Only
+// files named like our dumps are considered.
+func pruneDumps(dir string, keep retention, now time.Time) ([]string, error) {
+	removed := make([]string, 0)
+	if keep.hourly == 0 && keep.daily == 0 && keep.weekly == 0 {
+		return removed, nil
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// find all of the dumps, newest first
+	type dumpFile struct {
+		name string
+		t    time.Time
+	}
+	dumps := make([]dumpFile, 0)
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), dumpSuffix) {
+			continue
+		}
+		t, err := time.ParseInLocation(dumpTimeFormat, strings.TrimSuffix(file.Name(), dumpSuffix), now.Location())
+		if err != nil {
+			continue
+		}
+		dumps = append(dumps, dumpFile{file.Name(), t})
+	}
+	sort.Slice(dumps, func(i, j int) bool {
+		return dumps[i].t.After(dumps[j].t)
 	})
-	return err
+
+	// keep the newest dump in each period, for as many periods as we've been told to
+	keepers := make(map[string]bool)
+	periods := []struct {
+		count  int
+		period func(t time.Time) string
+	}{
+		{keep.hourly, func(t time.Time) string { return t.Format("2006010215") }},
+		{keep.daily, func(t time.Time) string { return t.Format("20060102") }},
+		{keep.weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-%d", year, week)
+		}},
+	}
+	for _, p := range periods {
+		seen := make(map[string]bool)
+		for _, d := range dumps {
+			if len(seen) >= p.count {
+				break
+			}
+			key := p.period(d.t)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			keepers[d.name] = true
+		}
+	}
+
+	for _, d := range dumps {
+		if keepers[d.name] {
+			continue
+		}
+		err := os.Remove(path.Join(dir, d.name))
+		if err != nil {
+			return removed, err
+		}
+		removed = append(removed, d.name)
+	}
+
+	return removed, nil
 }
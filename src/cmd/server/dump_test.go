@@ -0,0 +1,271 @@
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+
+	"internal/store"
+	"internal/types"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "daffy-server-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func touch(t *testing.T, filename string) {
+	err := ioutil.WriteFile(filename, []byte{}, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func listDir(t *testing.T, dir string) []string {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// gzipFile compresses src into dst, the same as a dump.
+func gzipFile(t *testing.T, src, dst string) {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := gzip.NewWriter(f)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPruneDumps(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	// Wednesday, in ISO week 24
+	now := time.Date(2017, 6, 14, 12, 30, 0, 0, time.Local)
+	names := []string{
+		"20170614-121000.db.gz", // newest this hour, today and this week
+		"20170614-120000.db.gz",
+		"20170614-110000.db.gz", // newest last hour
+		"20170614-100000.db.gz",
+		"20170613-230000.db.gz", // newest yesterday
+		"20170612-090000.db.gz",
+		"20170609-090000.db.gz", // newest in week 23
+		"20170608-090000.db.gz",
+		"20170501-000000.db.gz",
+		"garbage.db.gz", // not one of ours
+		"notes.txt",
+	}
+	for _, name := range names {
+		touch(t, path.Join(dir, name))
+	}
+
+	// nothing configured keeps everything
+	removed, err := pruneDumps(dir, retention{}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 0 {
+		t.Errorf("pruneDumps() with no retention removed %v", removed)
+	}
+
+	removed, err = pruneDumps(dir, retention{hourly: 2, daily: 2, weekly: 2}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(removed)
+	wantRemoved := []string{
+		"20170501-000000.db.gz",
+		"20170608-090000.db.gz",
+		"20170612-090000.db.gz",
+		"20170614-100000.db.gz",
+		"20170614-120000.db.gz",
+	}
+	if !reflect.DeepEqual(removed, wantRemoved) {
+		t.Errorf("pruneDumps() removed %v, want %v", removed, wantRemoved)
+	}
+	wantKept := []string{
+		"20170609-090000.db.gz",
+		"20170613-230000.db.gz",
+		"20170614-110000.db.gz",
+		"20170614-121000.db.gz",
+		"garbage.db.gz",
+		"notes.txt",
+	}
+	if kept := listDir(t, dir); !reflect.DeepEqual(kept, wantKept) {
+		t.Errorf("pruneDumps() kept %v, want %v", kept, wantKept)
+	}
+
+	// and again does nothing
+	removed, err = pruneDumps(dir, retention{hourly: 2, daily: 2, weekly: 2}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 0 {
+		t.Errorf("second pruneDumps() removed %v", removed)
+	}
+}
+
+func TestDumpAndRestore(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	boltStore := store.NewBoltStore(path.Join(dir, "live.db"))
+	if err := boltStore.Open(); err != nil {
+		t.Fatal(err)
+	}
+	user, err := boltStore.LogIn("", types.SocialLogIn{Provider: "github", Id: "1", NickName: "chilts"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dumpDir := path.Join(dir, "dumps")
+	if err := os.Mkdir(dumpDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	filename, err := dump(dumpDir, boltStore)
+	boltStore.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyDump(filename); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filename); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("dump mode = %v (%v), want 0600", info.Mode().Perm(), err)
+	}
+
+	// restoring over an existing datastore keeps it as a backup
+	dbFile := path.Join(dir, "daffy.db")
+	touch(t, dbFile)
+	if err := restoreDump(filename, dbFile); err != nil {
+		t.Fatal(err)
+	}
+	backups := 0
+	for _, name := range listDir(t, dir) {
+		if path.Ext(name) == ".bak" {
+			backups++
+		}
+	}
+	if backups != 1 {
+		t.Errorf("found %d backups, want 1: %v", backups, listDir(t, dir))
+	}
+
+	restored := store.NewBoltStore(dbFile)
+	if err := restored.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	if got, err := restored.GetUser(user.Id); err != nil || got == nil || got.Name != user.Name {
+		t.Errorf("GetUser() after restore = %#v, %v", got, err)
+	}
+}
+
+func TestRestorePreMetaDump(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	// a datastore from before the schema version was kept
+	oldFile := path.Join(dir, "old.db")
+	db, err := bolt.Open(oldFile, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{"user", "social", "i-u-n-u"} {
+			if _, err := tx.CreateBucket([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := path.Join(dir, "20170101-000000"+dumpSuffix)
+	gzipFile(t, oldFile, filename)
+
+	if err := verifyDump(filename); err != nil {
+		t.Fatalf("verifyDump() = %v, want a pre-meta dump to be valid", err)
+	}
+
+	dbFile := path.Join(dir, "daffy.db")
+	if err := restoreDump(filename, dbFile); err != nil {
+		t.Fatal(err)
+	}
+
+	// which is brought up to date when opened
+	restored := store.NewBoltStore(dbFile)
+	if err := restored.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	if version, _ := restored.GetSchemaVersion(); version != store.SchemaVersion() {
+		t.Errorf("schema version = %d, want %d", version, store.SchemaVersion())
+	}
+}
+
+func TestRestoreRefusesBadDump(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	notBolt := path.Join(dir, "not-bolt")
+	if err := ioutil.WriteFile(notBolt, []byte("hello"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	filename := path.Join(dir, "20170101-000000"+dumpSuffix)
+	gzipFile(t, notBolt, filename)
+	os.Remove(notBolt)
+
+	dbFile := path.Join(dir, "daffy.db")
+	if err := restoreDump(filename, dbFile); err == nil {
+		t.Errorf("restoreDump() of a bad dump succeeded")
+	}
+	if names := listDir(t, dir); !reflect.DeepEqual(names, []string{"20170101-000000" + dumpSuffix}) {
+		t.Errorf("restoreDump() of a bad dump left %v", names)
+	}
+}
+
+func TestDumpAndPruneRemovesBadDump(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	// the MemStore doesn't dump as a BoltDB, so it never verifies
+	memStore := store.NewMemStore()
+	if err := memStore.Open(); err != nil {
+		t.Fatal(err)
+	}
+	dumpAndPrune(dir, memStore, retention{hourly: 1})
+
+	if names := listDir(t, dir); len(names) != 0 {
+		t.Errorf("dumpAndPrune() kept an unverified dump: %v", names)
+	}
+}
@@ -6,10 +6,15 @@ import (
 
 	"github.com/gomiddleware/mux"
 	"github.com/markbates/goth/gothic"
+
+	"internal/sess"
 )
 
 func init() {
 	gothic.GetProviderName = getProviderName
+
+	// handlers.AuthBeginHandler() makes a signed state for each log in attempt, rather than gothic's fixed "state"
+	gothic.SetState = sess.GetAuthState
 }
 
 func getProviderName(r *http.Request) (string, error) {
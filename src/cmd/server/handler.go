@@ -2,7 +2,11 @@ package main
 
 // https://gist.github.com/chilts/db1adfaddaae871b161d7eadab6b1278
 
-import "net/http"
+import (
+	"net/http"
+
+	"internal/errpage"
+)
 
 func serveFile(filename string) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -21,5 +25,5 @@ func redirect(path string) func(http.ResponseWriter, *http.Request) {
 }
 
 func notFound(w http.ResponseWriter, r *http.Request) {
-	http.NotFound(w, r)
+	errpage.NotFound(w, r)
 }
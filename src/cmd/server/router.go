@@ -0,0 +1,101 @@
+package main
+
+import (
+	"html/template"
+	"time"
+
+	"github.com/gomiddleware/logger"
+	"github.com/gomiddleware/mux"
+	"github.com/gomiddleware/slash"
+	"github.com/gorilla/sessions"
+
+	"internal/errpage"
+	"internal/handlers"
+	"internal/middleware"
+	"internal/provider"
+	"internal/store"
+)
+
+// newRouter sets up every route. With devLogin, the dev provider's log in page is added too, which lets anyone log in
+// as anyone. Check the returned mux's Err before using it.
+func newRouter(sessionStore sessions.Store, api store.Api, providers []provider.Provider, tmpl *template.Template, stateKey []byte, deleteGracePeriod time.Duration, devLogin bool) *mux.Mux {
+	m := mux.New()
+
+	// do some static routes before doing logging
+	m.All("/s", fileServer("static"))
+	m.Get("/favicon.ico", serveFile("./static/favicon.ico"))
+	m.Get("/robots.txt", serveFile("./static/robots.txt"))
+
+	// some middlewares to always run
+	m.Use("/", logger.New())
+	m.Use("/", errpage.Recover)
+	m.Use("/", middleware.LoadUser(sessionStore, sessionName, api))
+	// and again, so the 500 page from any handler knows who is logged in
+	m.Use("/", errpage.Recover)
+	m.Use("/", middleware.CSRF(sessionStore, sessionName))
+
+	// home
+	m.Get("/", handlers.HomeHandler(sessionStore, sessionName, providers, tmpl))
+
+	// session
+	m.Post("/logout", handlers.LogoutHandler(sessionStore, sessionName, api))
+
+	// public user pages
+	m.Get("/u", slash.Add)
+	m.Get("/u/", redirect("/"))
+	m.Get("/u/:username", handlers.ProfileHandler(sessionStore, sessionName, providers, api, tmpl))
+
+	// user routes
+	m.Get("/my", slash.Add)
+	m.Use("/my", middleware.CheckUser(sessionStore, sessionName))
+	m.Get("/my/", handlers.MyHandler(sessionStore, sessionName, api, tmpl))
+
+	// tweet from a social account
+	m.Get("/my/tweet", middleware.LoadSocials(api), handlers.MyTweetHandlerGet(sessionStore, sessionName, api, tmpl))
+	m.Post("/my/tweet", middleware.LoadSocials(api), handlers.MyTweetHandlerPost(sessionStore, sessionName, api, tmpl))
+
+	// settings
+	m.Get("/settings", slash.Add)
+	m.Use("/settings", middleware.CheckUser(sessionStore, sessionName))
+	m.Get("/settings/", handlers.SettingsHandler(sessionStore, sessionName, providers, api, tmpl))
+	m.Get("/settings/profile/", slash.Remove)
+	m.Post("/settings/profile", handlers.SettingsProfileHandler(sessionStore, sessionName, providers, api, tmpl))
+	m.Post("/settings/socials/:id/unlink", handlers.SettingsSocialUnlinkHandler(sessionStore, sessionName, api))
+	m.Get("/settings/merge/", slash.Remove)
+	m.Get("/settings/merge", handlers.SettingsMergeHandlerGet(sessionStore, sessionName, api, tmpl))
+	m.Post("/settings/merge", handlers.SettingsMergeHandlerPost(sessionStore, sessionName, api))
+	m.Get("/settings/sessions/", slash.Remove)
+	m.Get("/settings/sessions", handlers.SettingsSessionsHandler(sessionStore, sessionName, api, tmpl))
+	m.Post("/settings/sessions/revoke", handlers.SettingsSessionsRevokeAllHandler(sessionStore, sessionName, api))
+	m.Post("/settings/sessions/:id/revoke", handlers.SettingsSessionRevokeHandler(sessionStore, sessionName, api))
+	m.Get("/settings/tokens/", slash.Remove)
+	m.Get("/settings/tokens", handlers.SettingsTokensHandlerGet(sessionStore, sessionName, api, tmpl))
+	m.Post("/settings/tokens", handlers.SettingsTokensHandlerPost(api, tmpl))
+	m.Post("/settings/tokens/:id/revoke", handlers.SettingsTokenRevokeHandler(sessionStore, sessionName, api))
+	m.Get("/settings/export/", slash.Remove)
+	m.Get("/settings/export", handlers.SettingsExportHandler(api, tmpl))
+	m.Get("/settings/delete/", slash.Remove)
+	m.Get("/settings/delete", handlers.SettingsDeleteHandlerGet(sessionStore, sessionName, deleteGracePeriod, tmpl))
+	m.Post("/settings/delete", handlers.SettingsDeleteHandlerPost(sessionStore, sessionName, api, deleteGracePeriod))
+
+	// JSON API, using either the session or a personal access token
+	m.Use("/api", middleware.CheckToken(api))
+	m.Get("/api/v1/me", handlers.ApiMeHandlerGet)
+	m.Patch("/api/v1/me", handlers.ApiMeHandlerPatch(api))
+	m.Get("/api/v1/me/socials", handlers.ApiMeSocialsHandler(api))
+	m.Get("/api/v1/users/:username", handlers.ApiUserHandler(api))
+	m.All("/api", handlers.ApiNotFoundHandler)
+
+	// auth
+	if devLogin {
+		m.Get(provider.DevLoginPath, handlers.AuthDevLoginHandler(sessionStore, sessionName, providers, tmpl))
+	}
+	m.Get("/auth/:provider/", slash.Remove)
+	m.Get("/auth/:provider", handlers.AuthBeginHandler(sessionStore, sessionName, api, stateKey))
+	m.Get("/auth/:provider/callback", handlers.AuthProviderCallbackHandler(sessionStore, sessionName, api, stateKey))
+
+	// anything else is a 404
+	m.All("/", notFound)
+
+	return m
+}
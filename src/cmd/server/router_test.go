@@ -0,0 +1,148 @@
+package main
+
+import (
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/gothic"
+
+	"internal/errpage"
+	"internal/middleware"
+	"internal/provider"
+	"internal/store"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+// newTestServer runs the whole router over a MemStore, with the real templates. The client keeps its cookies and
+// follows redirects, like a browser.
+func newTestServer(t *testing.T, devLogin bool) (*httptest.Server, *http.Client) {
+	tmpl, err := template.New("").ParseGlob("../../../templates/mdl/*.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api := store.NewMemStore()
+	if err := api.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	sessionStore := sessions.NewCookieStore(testKey)
+	gothic.Store = sessionStore
+
+	goth.ClearProviders()
+	var providers []provider.Provider
+	if devLogin {
+		providers = append(providers, provider.UseDev())
+	}
+
+	errpage.Templates = tmpl
+	errpage.Providers = providers
+	errpage.GetUser = middleware.GetUser
+	errpage.GetCSRFToken = middleware.GetCSRFToken
+
+	m := newRouter(sessionStore, api, providers, tmpl, testKey, 0, devLogin)
+	if m.Err != nil {
+		t.Fatal(m.Err)
+	}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewServer(m), &http.Client{Jar: jar}
+}
+
+// get fetches the path, following any redirects, and gives back the final response with its body.
+func get(t *testing.T, client *http.Client, srv *httptest.Server, path string) (*http.Response, string) {
+	res, err := client.Get(srv.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, string(body)
+}
+
+func TestDevLogIn(t *testing.T) {
+	srv, client := newTestServer(t, true)
+	defer srv.Close()
+	defer goth.ClearProviders()
+
+	// settings needs a user, so we're sent home
+	res, _ := get(t, client, srv, "/settings/")
+	if res.Request.URL.Path != "/" {
+		t.Fatalf("GET /settings/ while logged out = %d at %s", res.StatusCode, res.Request.URL)
+	}
+
+	// off to the dev provider, which is our own log in page
+	res, body := get(t, client, srv, "/auth/dev?next=/settings/")
+	if res.StatusCode != http.StatusOK || res.Request.URL.Path != provider.DevLoginPath {
+		t.Fatalf("GET /auth/dev = %d at %s", res.StatusCode, res.Request.URL)
+	}
+	if !strings.Contains(body, "Dev log in is on") {
+		t.Errorf("dev log in page = %s", body)
+	}
+	state := res.Request.URL.Query().Get("state")
+	if state == "" {
+		t.Fatalf("no state in %s", res.Request.URL)
+	}
+
+	// log in as alice, and end up where we were going
+	callback := "/auth/dev/callback?" + url.Values{
+		"state":    {state},
+		"id":       {"1"},
+		"nickname": {"alice"},
+		"email":    {"alice@example.com"},
+	}.Encode()
+	res, body = get(t, client, srv, callback)
+	if res.StatusCode != http.StatusOK || res.Request.URL.Path != "/settings/" {
+		t.Fatalf("GET callback = %d at %s: %s", res.StatusCode, res.Request.URL, body)
+	}
+	if !strings.Contains(body, "alice-1") {
+		t.Errorf("settings doesn't show alice-1: %s", body)
+	}
+
+	// and still logged in afterwards
+	res, body = get(t, client, srv, "/settings/")
+	if res.StatusCode != http.StatusOK || !strings.Contains(body, "alice-1") {
+		t.Errorf("GET /settings/ = %d: %s", res.StatusCode, body)
+	}
+
+	// the state can't be used again
+	res, _ = get(t, client, srv, callback)
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("replayed callback = %d, want %d", res.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestDevLogInOff(t *testing.T) {
+	srv, client := newTestServer(t, false)
+	defer srv.Close()
+
+	res, _ := get(t, client, srv, provider.DevLoginPath)
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("GET %s = %d, want %d", provider.DevLoginPath, res.StatusCode, http.StatusNotFound)
+	}
+
+	res, _ = get(t, client, srv, "/auth/dev")
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("GET /auth/dev = %d, want %d", res.StatusCode, http.StatusBadRequest)
+	}
+
+	res, _ = get(t, client, srv, "/auth/dev/callback?id=1&nickname=alice")
+	if res.StatusCode == http.StatusOK {
+		t.Errorf("GET /auth/dev/callback logged in at %s", res.Request.URL)
+	}
+}
@@ -1,33 +1,37 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
-	"fmt"
 	"html/template"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
 	valid "github.com/asaskevich/govalidator"
-	"github.com/gomiddleware/logger"
-	"github.com/gomiddleware/mux"
-	"github.com/gomiddleware/slash"
 	"github.com/gorilla/sessions"
-	"github.com/markbates/goth"
 	"github.com/markbates/goth/gothic"
-	"github.com/markbates/goth/providers/github"
-	"github.com/markbates/goth/providers/gplus"
-	"github.com/markbates/goth/providers/twitter"
 
-	"internal/handlers"
+	"internal/errpage"
 	"internal/middleware"
+	"internal/provider"
 	"internal/store"
 	"internal/types"
 )
 
 var sessionName = "session"
 
+// sessionMaxIdle is how long a logged in session can go unused before we forget about it, which matches the default
+// MaxAge of the session cookie.
+var sessionMaxIdle = 30 * 24 * time.Hour
+
+// userCacheTTL is how long the logged in user is cached for between requests.
+var userCacheTTL = 10 * time.Second
+
 // To create newer keys, setup two V3 environment variables and drop the V1 ones (or keep them for a while). Eventually
 // you can drop them. Keep incrementing each time you add new ones. See : https://godoc.org/github.com/gorilla/sessions
 var sessionStore = sessions.NewCookieStore(
@@ -39,6 +43,10 @@ var sessionStore = sessions.NewCookieStore(
 	[]byte(os.Getenv("DAFFY_SESSION_ENC_KEY_V1")),
 )
 
+// authStateKey signs the state of each log in attempt. Changing the session keys only means any log ins which are
+// part-way through will need starting again.
+var authStateKey = []byte(os.Getenv("DAFFY_SESSION_AUTH_KEY_V2"))
+
 func check(err error) {
 	if err != nil {
 		log.Fatal(err)
@@ -49,21 +57,50 @@ func init() {
 	// tell gothic where our session store is
 	gothic.Store = sessionStore
 
-	// Register the user with `gob` so we can serialise it.
-	gob.Register(&types.User{})
+	// Register the flash messages with `gob` so we can serialise them. The session only holds the user's Id, which
+	// means any old cookie still holding a whole user can't be decoded and is simply logged out.
+	gob.Register(types.Flash{})
 
 	// fail if fields haven't been set, or explicitely marked as optional
 	valid.SetFieldsRequiredByDefault(true)
 }
 
 func main() {
+	// maintenance commands, e.g. `server db rekey`
+	if len(os.Args) > 1 && os.Args[1] == "db" {
+		check(dbCommand(os.Args[2:]))
+		return
+	}
+
 	// setup
 	baseUrl := os.Getenv("DAFFY_BASE_URL")
 	port := os.Getenv("DAFFY_PORT")
 	if port == "" {
 		log.Fatal("Specify a port to listen on in the environment variable 'DAFFY_PORT'")
 	}
+	if len(authStateKey) == 0 {
+		log.Fatal("Specify a session auth key in the environment variable 'DAFFY_SESSION_AUTH_KEY_V2'")
+	}
+	devMode := os.Getenv("DAFFY_DEV_MODE") == "true"
+	// the OAuth tokens are only stored in plaintext when trying things out locally
+	if os.Getenv("DAFFY_TOKEN_ENC_KEY_V2") == "" && !devMode {
+		log.Fatal("Specify a token encryption key in the environment variable 'DAFFY_TOKEN_ENC_KEY_V2' (or set DAFFY_DEV_MODE=true)")
+	}
+	devLogin := os.Getenv("DAFFY_DEV_LOGIN") == "true"
 	dbDumpDir := os.Getenv("DAFFY_DB_DUMP_DIR")
+	dbDumpKeep := retention{
+		hourly: envInt("DAFFY_DB_DUMP_KEEP_HOURLY", 24),
+		daily:  envInt("DAFFY_DB_DUMP_KEEP_DAILY", 7),
+		weekly: envInt("DAFFY_DB_DUMP_KEEP_WEEKLY", 4),
+	}
+
+	// how long to wait before actually purging an account the user has asked us to delete (default is immediately)
+	var deleteGracePeriod time.Duration
+	if grace := os.Getenv("DAFFY_ACCOUNT_DELETE_GRACE_PERIOD"); grace != "" {
+		var errGrace error
+		deleteGracePeriod, errGrace = time.ParseDuration(grace)
+		check(errGrace)
+	}
 
 	// load up all templates
 	tmpl, err := template.New("").ParseGlob("./templates/mdl/*.html")
@@ -72,116 +109,154 @@ func main() {
 	}
 
 	// create/open/connect to a store
-	boltStore := store.NewBoltStore("daffy.db")
+	boltStore, errNew := newBoltStore()
+	check(errNew)
 	errOpen := boltStore.Open()
 	check(errOpen)
 	defer boltStore.Close()
 
-	// dump this BoltDB to disk every x mins
+	// the handlers look the user up on every request, so put a small cache in front of the store
+	api := store.NewCacheStore(boltStore, userCacheTTL)
+
+	// dump this BoltDB to disk every hour, until stopDumps() waits for any dump in progress and stops
+	stopDumps := func() {}
 	if dbDumpDir == "" {
 		log.Println("No DB_DUMP_DIR specified - not performing datastore dumps")
 	} else {
 		ticker := time.NewTicker(1 * time.Hour)
 		quit := make(chan struct{})
+		done := make(chan struct{})
 		go func() {
+			defer close(done)
 			for {
 				select {
 				case <-ticker.C:
 					// do stuff
 					log.Println("Dumping the DB now")
-					dump(dbDumpDir, boltStore.GetDB())
+					dumpAndPrune(dbDumpDir, boltStore, dbDumpKeep)
 				case <-quit:
 					ticker.Stop()
 					return
 				}
 			}
 		}()
+		stopDumps = func() {
+			close(quit)
+			<-done
+		}
 	}
 
-	// Example : https://raw.githubusercontent.com/markbates/goth/master/examples/main.go
-
-	// Twitter
-	twitterConsumerKey := os.Getenv("DAFFY_TWITTER_CONSUMER_KEY")
-	twitterConsumerSecret := os.Getenv("DAFFY_TWITTER_CONSUMER_SECRET")
-	if twitterConsumerKey != "" {
-		twitterProvider := twitter.NewAuthenticate(twitterConsumerKey, twitterConsumerSecret, baseUrl+"/auth/twitter/callback")
-		goth.UseProviders(twitterProvider)
+	// purge any accounts whose deletion grace period has passed
+	if deleteGracePeriod > 0 {
+		go func() {
+			for {
+				// through the cache, so purged users are forgotten straight away
+				purged, err := api.PurgeUsers(time.Now())
+				if err != nil {
+					log.Printf("Error purging deleted users: %s\n", err)
+				} else if len(purged) > 0 {
+					log.Printf("Purged %d deleted users: %v\n", len(purged), purged)
+				}
+				time.Sleep(1 * time.Hour)
+			}
+		}()
 	}
 
-	// Google (Plus)
-	//
-	gplusClientId := os.Getenv("DAFFY_GPLUS_CLIENT_ID")
-	gplusClientSecret := os.Getenv("DAFFY_GPLUS_CLIENT_SECRET")
-	if gplusClientId != "" {
-		gplusProvider := gplus.New(gplusClientId, gplusClientSecret, baseUrl+"/auth/gplus/callback")
-		goth.UseProviders(gplusProvider)
-	}
+	// forget any sessions which haven't been used for a while, and any log ins which were never finished
+	go func() {
+		for {
+			purged, err := boltStore.PurgeSessions(time.Now().Add(-sessionMaxIdle))
+			if err != nil {
+				log.Printf("Error purging old sessions: %s\n", err)
+			} else if purged > 0 {
+				log.Printf("Purged %d old sessions\n", purged)
+			}
+			abandoned, err := boltStore.PurgeAuthStates(time.Now())
+			if err != nil {
+				log.Printf("Error purging abandoned log ins: %s\n", err)
+			} else if abandoned > 0 {
+				log.Printf("Purged %d abandoned log ins\n", abandoned)
+			}
+			time.Sleep(1 * time.Hour)
+		}
+	}()
 
-	// GitHub
-	githubClientId := os.Getenv("DAFFY_GITHUB_CLIENT_ID")
-	githubClientSecret := os.Getenv("DAFFY_GITHUB_CLIENT_SECRET")
-	if githubClientId != "" {
-		githubProvider := github.New(githubClientId, githubClientSecret, baseUrl+"/auth/github/callback")
-		goth.UseProviders(githubProvider)
+	// social providers, see provider.FromEnv() for how to configure them
+	providerConfigs, errConfig := provider.FromEnv(os.Getenv)
+	check(errConfig)
+	providers, errProviders := provider.Use(providerConfigs, baseUrl)
+	check(errProviders)
+	// the dev provider lets anyone log in as anyone, so it has to be asked for on its own
+	if devLogin {
+		log.Println("***********************************************************************")
+		log.Println("* WARNING: DAFFY_DEV_LOGIN is on, so ANYONE can log in as ANY user    *")
+		log.Println("* with the dev provider. Never turn this on anywhere but your laptop. *")
+		log.Println("***********************************************************************")
+		providers = append(providers, provider.UseDev())
+	}
+	for _, p := range providers {
+		log.Printf("Using provider %s (%s)\n", p.Name, p.Title)
 	}
 
-	// Get the providers in use - you could use this to send to your templates so that they know which login links to
-	// support, however, you could also just hard-code them in the templates if you're only using one or two.
-	providers := goth.GetProviders()
-	fmt.Printf("providers=%#v\n", providers)
-
-	// set up some middleware in advance
-	checkUser := middleware.CheckUser(sessionStore, sessionName)
+	// error pages, which only show the real error in dev mode
+	errpage.Templates = tmpl
+	errpage.Providers = providers
+	errpage.GetUser = middleware.GetUser
+	errpage.GetCSRFToken = middleware.GetCSRFToken
+	errpage.DevMode = devMode
 
 	// router
-	m := mux.New()
-
-	// do some static routes before doing logging
-	m.All("/s", fileServer("static"))
-	m.Get("/favicon.ico", serveFile("./static/favicon.ico"))
-	m.Get("/robots.txt", serveFile("./static/robots.txt"))
-
-	// some middlewares to always run
-	m.Use("/", logger.New())
-
-	// home
-	m.Get("/", handlers.HomeHandler(sessionStore, sessionName, providers, tmpl))
+	m := newRouter(sessionStore, api, providers, tmpl, authStateKey, deleteGracePeriod, devLogin)
 
-	// session
-	m.Get("/logout/", slash.Remove)
-	m.Get("/logout", handlers.LogoutHandler(sessionStore, sessionName))
+	// finally, check all routing was added correctly
+	check(m.Err)
 
-	// public user pages
-	m.Get("/u", slash.Add)
-	m.Get("/u/", redirect("/"))
-	m.Get("/u/:username", handlers.ProfileHandler(sessionStore, sessionName, providers, boltStore, tmpl))
+	// server
+	server := &http.Server{
+		Addr:    ":" + port,
+		Handler: m,
+	}
 
-	// user routes
-	m.Get("/my", slash.Add)
-	m.Use("/my", checkUser)
-	m.Get("/my/", handlers.MyHandler(sessionStore, sessionName, boltStore, tmpl))
+	// on a clean shutdown, finish off any requests and take a final dump
+	idle := make(chan struct{})
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
 
-	// tweet from a social account
-	m.Get("/my/tweet", middleware.LoadSocials(sessionStore, sessionName, boltStore), handlers.MyTweetHandlerGet(sessionStore, sessionName, boltStore, tmpl))
-	m.Post("/my/tweet", middleware.LoadSocials(sessionStore, sessionName, boltStore), handlers.MyTweetHandlerPost(sessionStore, sessionName, boltStore, tmpl))
+		log.Println("Shutting down server")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down server: %s\n", err)
+		}
 
-	// settings
-	m.Get("/settings", slash.Add)
-	m.Use("/settings", checkUser)
-	m.Get("/settings/", handlers.SettingsHandler(sessionStore, sessionName, boltStore, tmpl))
-	m.Get("/settings/profile/", slash.Remove)
-	m.Post("/settings/profile", handlers.SettingsProfileHandler(sessionStore, sessionName, boltStore))
+		stopDumps()
+		if dbDumpDir != "" {
+			log.Println("Dumping the DB before exiting")
+			dumpAndPrune(dbDumpDir, boltStore, dbDumpKeep)
+		}
+		close(idle)
+	}()
 
-	// auth
-	m.Get("/auth/:provider/", slash.Remove)
-	m.Get("/auth/:provider", gothic.BeginAuthHandler)
-	m.Get("/auth/:provider/callback", handlers.AuthProviderCallbackHandler(sessionStore, sessionName, boltStore))
+	log.Printf("Starting server, listening on port %s\n", port)
+	errServer := server.ListenAndServe()
+	if errServer != http.ErrServerClosed {
+		check(errServer)
+	}
 
-	// finally, check all routing was added correctly
-	check(m.Err)
+	<-idle
+}
 
-	// server
-	log.Printf("Starting server, listening on port %s\n", port)
-	errServer := http.ListenAndServe(":"+port, m)
-	check(errServer)
+// envInt reads an integer from the environment, or returns def if it isn't set.
+func envInt(name string, def int) int {
+	str := os.Getenv(name)
+	if str == "" {
+		return def
+	}
+	i, err := strconv.Atoi(str)
+	if err != nil {
+		log.Fatalf("Environment variable '%s' must be a number: %s", name, err)
+	}
+	return i
 }
@@ -0,0 +1,197 @@
+package errpage
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"regexp"
+
+	uuid "github.com/hashicorp/go-uuid"
+
+	"internal/provider"
+	"internal/types"
+)
+
+// These are set once at startup, before serving any requests.
+var (
+	// Templates must contain `403.html`, `404.html` and `500.html`, which is used for any other status.
+	Templates *template.Template
+
+	// Providers is passed to the templates so the header can show the log in links.
+	Providers []provider.Provider
+
+	// GetUser finds the logged in user (if any) so the header can show them.
+	GetUser func(r *http.Request) *types.User
+
+	// GetCSRFToken finds the token for the header's log out form.
+	GetCSRFToken func(r *http.Request) string
+
+	// DevMode shows the real error on the page. Never turn this on in production.
+	DevMode bool
+)
+
+// Render logs err against a new request ID and shows the user the error page for this status, including the request ID
+// so they can tell us about it. The error itself is only shown in DevMode.
+func Render(w http.ResponseWriter, r *http.Request, status int, err error) {
+	requestId := Log(r, status, err)
+
+	detail := ""
+	if DevMode && err != nil {
+		detail = err.Error()
+	}
+	render(w, r, status, requestId, "", detail)
+}
+
+// Log logs err against a new request ID, which it returns so it can be shown to the user. Use this when sending an
+// error some other way, e.g. as JSON.
+func Log(r *http.Request, status int, err error) string {
+	requestId := newRequestId(r)
+	log.Printf("%s %s %s: %d: %v\n", requestId, r.Method, r.URL.Path, status, err)
+	return requestId
+}
+
+// Message shows the error page for this status with a message which is safe (and helpful) for the user to see.
+func Message(w http.ResponseWriter, r *http.Request, status int, message string) {
+	render(w, r, status, newRequestId(r), message, "")
+}
+
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	render(w, r, http.StatusNotFound, newRequestId(r), "", "")
+}
+
+func Forbidden(w http.ResponseWriter, r *http.Request) {
+	render(w, r, http.StatusForbidden, newRequestId(r), "", "")
+}
+
+// Recover is middleware which turns a panic further down the chain into a 500 page, rather than a dropped connection.
+// Mount it after LoadUser so the page shows who is logged in. If the handler had already started its response, the
+// page can't replace it, so the connection is dropped instead of sending half a page. The same goes for
+// http.ErrAbortHandler, which is passed on for net/http to deal with.
+func Recover(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		rw := &responseWriter{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+
+			err, ok := v.(error)
+			if !ok {
+				err = fmt.Errorf("panic: %v", v)
+			}
+			if rw.wroteHeader {
+				Log(r, http.StatusInternalServerError, err)
+				panic(http.ErrAbortHandler)
+			}
+			Render(w, r, http.StatusInternalServerError, err)
+		}()
+
+		next.ServeHTTP(rw, r)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// responseWriter remembers whether the response has been started.
+type responseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.wroteHeader = true
+		f.Flush()
+	}
+}
+
+func render(w http.ResponseWriter, r *http.Request, status int, requestId, message, detail string) {
+	var user *types.User
+	if GetUser != nil {
+		user = GetUser(r)
+	}
+	csrf := ""
+	if GetCSRFToken != nil {
+		csrf = GetCSRFToken(r)
+	}
+
+	data := struct {
+		Title      string
+		User       *types.User
+		Flashes    []types.Flash
+		CSRF       string
+		Providers  []provider.Provider
+		Status     int
+		StatusText string
+		RequestId  string
+		Message    string
+		Detail     string
+	}{
+		http.StatusText(status) + " - daffy.io",
+		user,
+		nil,
+		csrf,
+		Providers,
+		status,
+		http.StatusText(status),
+		requestId,
+		message,
+		detail,
+	}
+
+	tmplName := fmt.Sprintf("%d.html", status)
+	if Templates == nil || Templates.Lookup(tmplName) == nil {
+		tmplName = "500.html"
+	}
+
+	buf := &bytes.Buffer{}
+	if Templates != nil {
+		err := Templates.ExecuteTemplate(buf, tmplName, data)
+		if err != nil {
+			log.Printf("%s error rendering %s: %s\n", requestId, tmplName, err)
+			buf.Reset()
+		}
+	}
+
+	// if all else fails, fall back to plain text
+	if buf.Len() == 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "%d %s (request %s)\n", status, http.StatusText(status), requestId)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
+
+// requestIdRegexp is what we accept as an `X-Request-Id`, since it goes into the logs and onto the page.
+var requestIdRegexp = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// newRequestId uses the ID given to us by the proxy in front of us (if it looks like one), otherwise it makes a new
+// one. Anyone can send the header, so nothing but a short plain ID is used.
+func newRequestId(r *http.Request) string {
+	if id := r.Header.Get("X-Request-Id"); requestIdRegexp.MatchString(id) {
+		return id
+	}
+	id, _ := uuid.GenerateUUID()
+	return id
+}
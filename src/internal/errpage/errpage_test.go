@@ -0,0 +1,110 @@
+package errpage
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"internal/types"
+)
+
+type userKey struct{}
+
+func setup() {
+	Templates = template.Must(template.New("500.html").Parse(`{{ .Status }} {{ with .User }}{{ .Name }}{{ else }}anon{{ end }}`))
+	GetUser = func(r *http.Request) *types.User {
+		user, _ := r.Context().Value(userKey{}).(*types.User)
+		return user
+	}
+}
+
+// serve runs the handler inside Recover, giving back the response and whatever was panicked out of Recover itself.
+func serve(handler http.HandlerFunc, r *http.Request) (rec *httptest.ResponseRecorder, panicked interface{}) {
+	rec = httptest.NewRecorder()
+	defer func() {
+		panicked = recover()
+	}()
+	Recover(handler).ServeHTTP(rec, r)
+	return rec, nil
+}
+
+func TestRecover(t *testing.T) {
+	setup()
+
+	// the page shows the logged in user
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r = r.WithContext(context.WithValue(r.Context(), userKey{}, &types.User{Name: "chilts"}))
+	rec, panicked := serve(func(w http.ResponseWriter, r *http.Request) {
+		panic("oops")
+	}, r)
+	if panicked != nil {
+		t.Fatalf("Recover() panicked with %v", panicked)
+	}
+	if rec.Code != http.StatusInternalServerError || rec.Body.String() != "500 chilts" {
+		t.Errorf("response = %d %q, want %d %q", rec.Code, rec.Body.String(), http.StatusInternalServerError, "500 chilts")
+	}
+}
+
+func TestRecoverAfterWriteHeader(t *testing.T) {
+	setup()
+
+	// once the response has started, the error page can't be added to it
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec, panicked := serve(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("half a page"))
+		panic("oops")
+	}, r)
+	if panicked != http.ErrAbortHandler {
+		t.Errorf("Recover() panicked with %v, want %v", panicked, http.ErrAbortHandler)
+	}
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "500") {
+		t.Errorf("response = %d %q, want the partial response untouched", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRecoverAbortHandler(t *testing.T) {
+	setup()
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec, panicked := serve(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}, r)
+	if panicked != http.ErrAbortHandler {
+		t.Errorf("Recover() panicked with %v, want %v", panicked, http.ErrAbortHandler)
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("response body = %q, want nothing", rec.Body.String())
+	}
+}
+
+func TestNewRequestId(t *testing.T) {
+	tests := []struct {
+		header string
+		keep   bool
+	}{
+		{"", false},
+		{"f81d4fae-7dec-11d0-a765-00a0c91e6bf6", true},
+		{"1-67891233.abcdef_012", true},
+		{strings.Repeat("a", 64), true},
+		{strings.Repeat("a", 65), false},
+		{"abc\nFAKE log line", false},
+		{"<script>alert(1)</script>", false},
+		{"abc def", false},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Request-Id", test.header)
+		id := newRequestId(r)
+		if test.keep && id != test.header {
+			t.Errorf("newRequestId(%q) = %q, want the header", test.header, id)
+		}
+		if !test.keep && (id == test.header || !requestIdRegexp.MatchString(id)) {
+			t.Errorf("newRequestId(%q) = %q, want a new ID", test.header, id)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/chilts/logfn"
+	"github.com/gomiddleware/mux"
+
+	"internal/errpage"
+	"internal/middleware"
+	"internal/store"
+	"internal/types"
+)
+
+// The JSON API under `/api/v1`. Every response is JSON, including errors which all look like:
+//
+//     {"error":{"status":400,"message":"Bad Request","fields":{"userName":"Please choose a username"}}}
+//
+// Field names match the names used in the HTML forms, e.g. `userName`.
+//
+// You can authenticate with either the session cookie or a personal access token (see middleware.CheckToken). Reading
+// needs a token with the `read` scope and changing anything needs `write`. When using the session cookie, changes must
+// also send the `X-CSRF-Token` header (from the page's `csrf-token` meta tag), see middleware.CSRF.
+
+type apiErrorDoc struct {
+	Error apiError `json:"error"`
+}
+
+type apiError struct {
+	Status    int        `json:"status"`
+	Message   string     `json:"message"`
+	Fields    formErrors `json:"fields,omitempty"`
+	RequestId string     `json:"requestId,omitempty"`
+}
+
+type apiUser struct {
+	Id          string     `json:"id"`
+	Name        string     `json:"userName"`
+	Title       string     `json:"title"`
+	Email       string     `json:"email"`
+	SocialIds   []string   `json:"socialIds"`
+	DeleteAfter *time.Time `json:"deleteAfter,omitempty"`
+	Inserted    time.Time  `json:"inserted"`
+	Updated     time.Time  `json:"updated"`
+}
+
+type apiPublicUser struct {
+	Name     string    `json:"userName"`
+	Title    string    `json:"title"`
+	Inserted time.Time `json:"inserted"`
+}
+
+type apiSocial struct {
+	Id        string     `json:"id"`
+	Provider  string     `json:"provider"`
+	NickName  string     `json:"nickName"`
+	Title     string     `json:"title"`
+	Email     string     `json:"email"`
+	AvatarURL string     `json:"avatarUrl,omitempty"`
+	LastLogin *time.Time `json:"lastLogin,omitempty"`
+	Inserted  time.Time  `json:"inserted"`
+	Updated   time.Time  `json:"updated"`
+}
+
+// apiUpdateUser is the body of a PATCH, where any field left out stays as it is.
+type apiUpdateUser struct {
+	Name  *string `json:"userName"`
+	Title *string `json:"title"`
+	Email *string `json:"email"`
+}
+
+func ApiMeHandlerGet(w http.ResponseWriter, r *http.Request) {
+	defer logfn.Exit(logfn.Enter("handlers.ApiMeHandlerGet"))
+
+	user, ok := apiGetUser(w, r, types.TokenScopeRead)
+	if !ok {
+		return
+	}
+
+	sendJson(w, http.StatusOK, newApiUser(*user))
+}
+
+func ApiMeHandlerPatch(api store.Api) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer logfn.Exit(logfn.Enter("handlers.ApiMeHandlerPatch"))
+
+		user, ok := apiGetUser(w, r, types.TokenScopeWrite)
+		if !ok {
+			return
+		}
+
+		patch := apiUpdateUser{}
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		err := dec.Decode(&patch)
+		if err != nil {
+			sendApiError(w, r, http.StatusBadRequest, "Invalid JSON: "+err.Error(), nil)
+			return
+		}
+
+		// start with what we have, and change whatever was given
+		updateUser := types.UpdateUser{
+			Name:  user.Name,
+			Title: user.Title,
+			Email: user.Email,
+		}
+		if patch.Name != nil {
+			updateUser.Name = *patch.Name
+		}
+		if patch.Title != nil {
+			updateUser.Title = *patch.Title
+		}
+		if patch.Email != nil {
+			updateUser.Email = *patch.Email
+		}
+
+		// the same rules as the settings form
+		if errs := validateForm(&updateUser); errs != nil {
+			sendApiError(w, r, http.StatusUnprocessableEntity, "Validation failed", errs)
+			return
+		}
+
+		newUser, err := api.UpdateUser(*user, updateUser)
+		if err == store.ErrUsernameAlreadyExists {
+			sendApiError(w, r, http.StatusConflict, err.Error(), formErrors{"userName": "Sorry, that username is already taken"})
+			return
+		}
+		if err != nil {
+			sendApiServerError(w, r, err)
+			return
+		}
+
+		sendJson(w, http.StatusOK, newApiUser(newUser))
+	}
+}
+
+func ApiMeSocialsHandler(api store.Api) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer logfn.Exit(logfn.Enter("handlers.ApiMeSocialsHandler"))
+
+		user, ok := apiGetUser(w, r, types.TokenScopeRead)
+		if !ok {
+			return
+		}
+
+		socials, err := api.SelSocials(user.SocialIds)
+		if err != nil {
+			sendApiServerError(w, r, err)
+			return
+		}
+
+		out := make([]apiSocial, 0, len(socials))
+		for _, social := range socials {
+			out = append(out, newApiSocial(social.Redacted()))
+		}
+		sendJson(w, http.StatusOK, out)
+	}
+}
+
+func ApiUserHandler(api store.Api) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer logfn.Exit(logfn.Enter("handlers.ApiUserHandler"))
+
+		vals := mux.Vals(r)
+
+		profile, err := api.GetUserPublic(vals["username"])
+		if err != nil {
+			sendApiServerError(w, r, err)
+			return
+		}
+		if profile == nil {
+			sendApiError(w, r, http.StatusNotFound, "Unknown user", nil)
+			return
+		}
+
+		sendJson(w, http.StatusOK, apiPublicUser{
+			Name:     profile.Name,
+			Title:    profile.Title,
+			Inserted: profile.Inserted,
+		})
+	}
+}
+
+// ApiNotFoundHandler catches anything under the API which doesn't exist, so it still gets a JSON response.
+func ApiNotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	sendApiError(w, r, http.StatusNotFound, http.StatusText(http.StatusNotFound), nil)
+}
+
+// apiGetUser gets the current user, whether they logged in with the session or a personal access token (which must have
+// this scope). It sends a 401 (or 403) if they aren't allowed in.
+func apiGetUser(w http.ResponseWriter, r *http.Request, scope string) (*types.User, bool) {
+	user := middleware.GetUser(r)
+	if user == nil {
+		sendApiError(w, r, http.StatusUnauthorized, "You must be logged in", nil)
+		return nil, false
+	}
+
+	if token := middleware.GetToken(r); token != nil && !token.HasScope(scope) {
+		sendApiError(w, r, http.StatusForbidden, "This token doesn't have the '"+scope+"' scope", nil)
+		return nil, false
+	}
+
+	return user, true
+}
+
+func newApiUser(user types.User) apiUser {
+	out := apiUser{
+		Id:        user.Id,
+		Name:      user.Name,
+		Title:     user.Title,
+		Email:     user.Email,
+		SocialIds: user.SocialIds,
+		Inserted:  user.Inserted,
+		Updated:   user.Updated,
+	}
+	if !user.DeleteAfter.IsZero() {
+		out.DeleteAfter = &user.DeleteAfter
+	}
+	if out.SocialIds == nil {
+		out.SocialIds = []string{}
+	}
+	return out
+}
+
+func newApiSocial(social types.Social) apiSocial {
+	out := apiSocial{
+		Id:        social.Id,
+		Provider:  social.Provider,
+		NickName:  social.NickName,
+		Title:     social.Title,
+		Email:     social.Email,
+		AvatarURL: social.AvatarURL,
+		Inserted:  social.Inserted,
+		Updated:   social.Updated,
+	}
+	if !social.LastLogin.IsZero() {
+		out.LastLogin = &social.LastLogin
+	}
+	return out
+}
+
+func sendJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func sendApiError(w http.ResponseWriter, r *http.Request, status int, message string, fields formErrors) {
+	sendJson(w, status, apiErrorDoc{apiError{
+		Status:  status,
+		Message: message,
+		Fields:  fields,
+	}})
+}
+
+// sendApiServerError logs the real error and sends a 500 with the request ID, just like the HTML error pages.
+func sendApiServerError(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusInternalServerError
+	requestId := errpage.Log(r, status, err)
+
+	message := http.StatusText(status)
+	if errpage.DevMode {
+		message = err.Error()
+	}
+
+	sendJson(w, status, apiErrorDoc{apiError{
+		Status:    status,
+		Message:   message,
+		RequestId: requestId,
+	}})
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"internal/middleware"
+	"internal/store"
+	"internal/types"
+)
+
+func newApiStore(t *testing.T) store.Api {
+	api := store.NewMemStore()
+	if err := api.Open(); err != nil {
+		t.Fatal(err)
+	}
+	return api
+}
+
+func mustLogIn(t *testing.T, api store.Api, provider, id, nickName string) *types.User {
+	user, err := api.LogIn("", types.SocialLogIn{
+		Provider:          provider,
+		Id:                id,
+		NickName:          nickName,
+		Title:             "Title of " + nickName,
+		Email:             nickName + "@example.com",
+		AccessToken:       "access-token-of-" + nickName,
+		AccessTokenSecret: "access-secret-of-" + nickName,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func mustInsToken(t *testing.T, api store.Api, user *types.User, scopes ...string) string {
+	_, secret, err := api.InsToken(*user, types.Token{Name: "test", Scopes: scopes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secret
+}
+
+// serveApi sends the request through CheckToken to the handler, as the router does, using the token if there is one.
+func serveApi(api store.Api, handler http.HandlerFunc, method, secret, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/api/v1/me", strings.NewReader(body))
+	if secret != "" {
+		r.Header.Set("Authorization", "Bearer "+secret)
+	}
+	rec := httptest.NewRecorder()
+	middleware.CheckToken(api)(handler).ServeHTTP(rec, r)
+	return rec
+}
+
+func decodeApiError(t *testing.T, rec *httptest.ResponseRecorder) apiError {
+	var doc apiErrorDoc
+	if err := json.NewDecoder(rec.Body).Decode(&doc); err != nil {
+		t.Fatalf("decoding error response: %v", err)
+	}
+	return doc.Error
+}
+
+func TestApiMePatchValidation(t *testing.T) {
+	api := newApiStore(t)
+	user := mustLogIn(t, api, "twitter", "1", "alice")
+	secret := mustInsToken(t, api, user, types.TokenScopeRead, types.TokenScopeWrite)
+
+	rec := serveApi(api, ApiMeHandlerPatch(api), http.MethodPatch, secret, `{"userName":"Not Valid!","email":"nope"}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusUnprocessableEntity, rec.Body)
+	}
+	apiErr := decodeApiError(t, rec)
+	if apiErr.Fields["userName"] == "" || apiErr.Fields["email"] == "" || apiErr.Fields["title"] != "" {
+		t.Errorf("fields = %v, want userName and email only", apiErr.Fields)
+	}
+
+	// nothing was changed
+	got, _ := api.GetUser(user.Id)
+	if got.Name != user.Name || got.Email != user.Email {
+		t.Errorf("user changed to %q, %q", got.Name, got.Email)
+	}
+
+	// and a field we don't know about is a bad request
+	rec = serveApi(api, ApiMeHandlerPatch(api), http.MethodPatch, secret, `{"admin":true}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body)
+	}
+
+	// whereas a valid change goes through
+	rec = serveApi(api, ApiMeHandlerPatch(api), http.MethodPatch, secret, `{"title":"Alice"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var out apiUser
+	if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Title != "Alice" || out.Name != user.Name {
+		t.Errorf("PATCH = %#v", out)
+	}
+}
+
+func TestApiMePatchConflict(t *testing.T) {
+	api := newApiStore(t)
+	alice := mustLogIn(t, api, "twitter", "1", "alice")
+	bob := mustLogIn(t, api, "twitter", "2", "bob")
+	secret := mustInsToken(t, api, alice, types.TokenScopeWrite)
+
+	rec := serveApi(api, ApiMeHandlerPatch(api), http.MethodPatch, secret, `{"userName":"`+bob.Name+`"}`)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusConflict, rec.Body)
+	}
+	if apiErr := decodeApiError(t, rec); apiErr.Fields["userName"] == "" {
+		t.Errorf("fields = %v, want userName", apiErr.Fields)
+	}
+	if got, _ := api.GetUser(alice.Id); got.Name != alice.Name {
+		t.Errorf("alice's name changed to %q", got.Name)
+	}
+}
+
+func TestApiScopes(t *testing.T) {
+	api := newApiStore(t)
+	user := mustLogIn(t, api, "twitter", "1", "alice")
+	readOnly := mustInsToken(t, api, user, types.TokenScopeRead)
+	writeOnly := mustInsToken(t, api, user, types.TokenScopeWrite)
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		secret  string
+		body    string
+		status  int
+	}{
+		{"read with read", ApiMeHandlerGet, http.MethodGet, readOnly, "", http.StatusOK},
+		{"read with write", ApiMeHandlerGet, http.MethodGet, writeOnly, "", http.StatusForbidden},
+		{"socials with write", ApiMeSocialsHandler(api), http.MethodGet, writeOnly, "", http.StatusForbidden},
+		{"patch with read", ApiMeHandlerPatch(api), http.MethodPatch, readOnly, `{"title":"Alice"}`, http.StatusForbidden},
+		{"patch with write", ApiMeHandlerPatch(api), http.MethodPatch, writeOnly, `{"title":"Alice"}`, http.StatusOK},
+		{"no token", ApiMeHandlerGet, http.MethodGet, "", "", http.StatusUnauthorized},
+		{"unknown token", ApiMeHandlerGet, http.MethodGet, "nope", "", http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := serveApi(api, test.handler, test.method, test.secret, test.body)
+			if rec.Code != test.status {
+				t.Errorf("status = %d, want %d: %s", rec.Code, test.status, rec.Body)
+			}
+		})
+	}
+}
+
+func TestApiMeSocialsRedacted(t *testing.T) {
+	api := newApiStore(t)
+	user := mustLogIn(t, api, "twitter", "1", "alice")
+	secret := mustInsToken(t, api, user, types.TokenScopeRead)
+
+	rec := serveApi(api, ApiMeSocialsHandler(api), http.MethodGet, secret, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	body := rec.Body.String()
+	if strings.Contains(body, "access-token-of-alice") || strings.Contains(body, "access-secret-of-alice") {
+		t.Errorf("response contains the social's tokens: %s", body)
+	}
+
+	var socials []apiSocial
+	if err := json.Unmarshal([]byte(body), &socials); err != nil {
+		t.Fatal(err)
+	}
+	if len(socials) != 1 || socials[0].Id != "twitter:1" || socials[0].NickName != "alice" {
+		t.Errorf("socials = %#v", socials)
+	}
+}
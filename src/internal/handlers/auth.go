@@ -1,8 +1,7 @@
 package handlers
 
 import (
-	"fmt"
-	"log"
+	"html/template"
 	"net/http"
 
 	"github.com/chilts/logfn"
@@ -10,15 +9,82 @@ import (
 	"github.com/gorilla/sessions"
 	"github.com/markbates/goth/gothic"
 
+	"internal/errpage"
+	"internal/provider"
+	"internal/sess"
 	"internal/store"
+	"internal/types"
 )
 
-func AuthProviderCallbackHandler(sessionStore sessions.Store, sessionName string, api store.Api) func(w http.ResponseWriter, r *http.Request) {
+// AuthBeginHandler starts a log in attempt (signing its state with stateKey) and sends the user off to the provider. A
+// `?next=` page is remembered for when they get back.
+func AuthBeginHandler(sessionStore sessions.Store, sessionName string, api store.Api, stateKey []byte) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer logfn.Exit(logfn.Enter("handlers.AuthBeginHandler"))
+
+		vals := mux.Vals(r)
+		provider := vals["provider"]
+
+		if next := r.URL.Query().Get("next"); next != "" {
+			sess.SetNext(r, sessionStore, sessionName, next)
+		}
+
+		state, err := sess.BeginAuth(r, sessionStore, sessionName, api, stateKey, provider)
+		if err != nil {
+			errpage.Render(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		authURL, err := gothic.GetAuthURL(w, sess.WithAuthState(r, state))
+		if err != nil {
+			errpage.Render(w, r, http.StatusBadRequest, err)
+			return
+		}
+		err = sess.BindRequestToken(r, sessionStore, sessionName, authURL)
+		if err != nil {
+			errpage.Render(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		sessions.Save(r, w)
+
+		http.Redirect(w, r, authURL, http.StatusTemporaryRedirect)
+	}
+}
+
+// AuthDevLoginHandler is the dev provider's stand-in for a real provider's log in page. It must only be mounted
+// when dev log in is on.
+func AuthDevLoginHandler(sessionStore sessions.Store, sessionName string, providers []provider.Provider, tmpl *template.Template) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer logfn.Exit(logfn.Enter("handlers.AuthDevLoginHandler"))
+
+		data := struct {
+			Title     string
+			User      *types.User
+			Flashes   []types.Flash
+			CSRF      string
+			Providers []provider.Provider
+			Users     []provider.DevUser
+			State     string
+		}{
+			"Dev Log In - daffy.io",
+			getUser(r),
+			getFlashes(w, r, sessionStore, sessionName),
+			csrfToken(r),
+			providers,
+			provider.DevUsers,
+			r.URL.Query().Get("state"),
+		}
+		render(w, tmpl, "dev-login.html", data)
+	}
+}
+
+func AuthProviderCallbackHandler(sessionStore sessions.Store, sessionName string, api store.Api, stateKey []byte) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer logfn.Exit(logfn.Enter("authProviderCallbackHandler"))
 
 		session, _ := sessionStore.Get(r, sessionName)
-		currentUser := getUserFromSession(r, sessionStore, sessionName)
+
+		currentUser := getUser(r)
 		userId := ""
 		if currentUser != nil {
 			userId = currentUser.Id
@@ -28,39 +94,77 @@ func AuthProviderCallbackHandler(sessionStore sessions.Store, sessionName string
 		vals := mux.Vals(r)
 		provider := vals["provider"]
 
+		// make sure this browser started this log in, before we ask the provider anything
+		err := sess.CompleteAuth(r, sessionStore, sessionName, api, stateKey, provider)
+		if err == sess.ErrAuthStateMismatch {
+			sessions.Save(r, w)
+			errpage.Message(w, r, http.StatusBadRequest, "This log in didn't start from this browser, or took too long. Please try logging in again.")
+			return
+		}
+		if err != nil {
+			errpage.Render(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
 		authUser, err := gothic.CompleteUserAuth(w, r)
 		if err != nil {
-			log.Print(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			errpage.Render(w, r, http.StatusInternalServerError, err)
 			return
 		}
 
-		fmt.Printf("authUser=%#v\n", authUser)
+		// gothic's own session is finished with too
+		gothSession, _ := gothic.Store.Get(r, gothic.SessionName)
+		delete(gothSession.Values, gothic.SessionName)
 
 		// check to see if this socialId already exists
 		user, err := api.LogInGoth(userId, provider, authUser)
+		if err == store.ErrSocialAccountAlreadyExists {
+			// The user has now proved they own both accounts, so offer to merge them.
+			other, errOther := api.GetUserBySocialId(types.SocialLogIn{Provider: provider, Id: authUser.UserID}.SocialId())
+			if errOther != nil {
+				errpage.Render(w, r, http.StatusInternalServerError, errOther)
+				return
+			}
+			if other == nil {
+				errpage.Render(w, r, http.StatusInternalServerError, err)
+				return
+			}
+
+			setMergeUserId(session, other.Id)
+			sessions.Save(r, w)
+
+			http.Redirect(w, r, "/settings/merge", http.StatusFound)
+			return
+		}
 		if err != nil {
-			log.Print(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			errpage.Render(w, r, http.StatusInternalServerError, err)
 			return
 		}
 
-		fmt.Printf("user=%#v\n", user)
-
 		// we always get a user back from LogIn()
 		if user == nil {
-			log.Print(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			errpage.Render(w, r, http.StatusInternalServerError, err)
 			return
 		}
 
-		// set this info in the session (whether new or updated with a new SocialId)
-		session.Values["user"] = &user
+		// log them in, unless they just connected another social to the user they're already logged in as
+		if currentUser != nil {
+			addFlash(r, sessionStore, sessionName, types.FlashSuccess, "Your "+provider+" account has been connected.")
+		} else {
+			err = sess.LogIn(r, sessionStore, sessionName, api, user.Id)
+			if err != nil {
+				errpage.Render(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			addFlash(r, sessionStore, sessionName, types.FlashSuccess, "Welcome, "+user.Name+"!")
+		}
+
+		// back to wherever they were going, otherwise the homepage
+		next := sess.TakeNext(r, sessionStore, sessionName, "/")
 
 		// save all sessions
 		sessions.Save(r, w)
 
-		// redirect back to homepage
-		http.Redirect(w, r, "/", http.StatusFound)
+		http.Redirect(w, r, next, http.StatusFound)
 	}
 }
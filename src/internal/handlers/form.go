@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+
+	valid "github.com/asaskevich/govalidator"
+
+	"internal/middleware"
+)
+
+// formErrors holds a message for each form field which failed validation, keyed on the field's `schema` name (ie. the
+// name of the input in the HTML form) so the template can show it next to the right box.
+type formErrors map[string]string
+
+// decodeForm decodes the posted form into dst (a pointer to a struct) using the shared decoder, then validates it
+// with its `valid` tags. The error is only set if the form couldn't be read at all, whereas anything the user needs to
+// fix is returned in the formErrors.
+func decodeForm(r *http.Request, dst interface{}) (formErrors, error) {
+	err := r.ParseForm()
+	if err != nil {
+		return nil, err
+	}
+
+	err = decoder.Decode(dst, postForm(r))
+	if err != nil {
+		return nil, err
+	}
+
+	return validateForm(dst), nil
+}
+
+// postForm gives the posted form without the CSRF token, which has already been checked by middleware.CSRF and isn't
+// part of any of our types. Call r.ParseForm() first.
+func postForm(r *http.Request) url.Values {
+	form := make(url.Values, len(r.PostForm))
+	for name, values := range r.PostForm {
+		if name != middleware.CSRFFieldName {
+			form[name] = values
+		}
+	}
+	return form
+}
+
+// validateForm runs govalidator over v and returns any problems keyed on each field's `schema` name. It returns nil if
+// everything is valid.
+func validateForm(v interface{}) formErrors {
+	_, err := valid.ValidateStruct(v)
+	if err == nil {
+		return nil
+	}
+
+	t := reflect.Indirect(reflect.ValueOf(v)).Type()
+	errs := make(formErrors)
+
+	for _, err := range flattenErrors(err) {
+		// anything which isn't for a particular field can't be shown next to one
+		fieldErr, ok := err.(valid.Error)
+		if !ok {
+			errs[""] = err.Error()
+			continue
+		}
+		name := schemaName(t, fieldErr.Name)
+		if _, exists := errs[name]; !exists {
+			errs[name] = fieldErr.Err.Error()
+		}
+	}
+
+	return errs
+}
+
+// flattenErrors turns govalidator's (possibly nested) Errors into a plain list.
+func flattenErrors(err error) []error {
+	errs, ok := err.(valid.Errors)
+	if !ok {
+		return []error{err}
+	}
+
+	flat := make([]error, 0, len(errs))
+	for _, err := range errs {
+		flat = append(flat, flattenErrors(err)...)
+	}
+	return flat
+}
+
+// schemaName gives the name the decoder uses for the struct field, which is the `schema` tag if there is one.
+func schemaName(t reflect.Type, fieldName string) string {
+	field, ok := t.FieldByName(fieldName)
+	if !ok {
+		return fieldName
+	}
+
+	name := strings.Split(field.Tag.Get("schema"), ",")[0]
+	if name == "" || name == "-" {
+		return fieldName
+	}
+	return name
+}
@@ -6,24 +6,28 @@ import (
 
 	"github.com/chilts/logfn"
 	"github.com/gorilla/sessions"
-	"github.com/markbates/goth"
 
+	"internal/provider"
 	"internal/types"
 )
 
-func HomeHandler(sessionStore sessions.Store, sessionName string, providers goth.Providers, tmpl *template.Template) func(w http.ResponseWriter, r *http.Request) {
+func HomeHandler(sessionStore sessions.Store, sessionName string, providers []provider.Provider, tmpl *template.Template) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer logfn.Exit(logfn.Enter("homeHandler"))
 
-		user := getUserFromSession(r, sessionStore, sessionName)
+		user := getUser(r)
 
 		data := struct {
 			Title     string
 			User      *types.User
-			Providers goth.Providers
+			Flashes   []types.Flash
+			CSRF      string
+			Providers []provider.Provider
 		}{
 			"Daffy",
 			user,
+			getFlashes(w, r, sessionStore, sessionName),
+			csrfToken(r),
 			providers,
 		}
 
@@ -1,73 +1,106 @@
 package handlers
 
 import (
-	"fmt"
+	"encoding/json"
 	"html/template"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"os"
+	"net/url"
 
 	"github.com/chilts/logfn"
 	"github.com/gorilla/sessions"
 	"github.com/mrjones/oauth"
 
+	"internal/errpage"
 	"internal/middleware"
+	"internal/provider"
 	"internal/store"
 	"internal/types"
 )
 
-func MyTweetHandlerGet(sessionStore sessions.Store, sessionName string, boltStore *store.BoltStore, tmpl *template.Template) func(w http.ResponseWriter, r *http.Request) {
+func MyTweetHandlerGet(sessionStore sessions.Store, sessionName string, api store.Api, tmpl *template.Template) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer logfn.Exit(logfn.Enter("handlers.MyTweetHandlerGet"))
 
-		user := getUserFromSession(r, sessionStore, sessionName)
-		socials := middleware.GetSocials(r)
+		user := getUser(r)
+
+		// only Twitter accounts can tweet
+		var socials []types.Social
+		for _, social := range middleware.GetSocials(r) {
+			if social.Provider == "twitter" {
+				socials = append(socials, social)
+			}
+		}
 
 		data := struct {
 			Title   string
 			User    *types.User
+			Flashes []types.Flash
+			CSRF    string
 			Socials []types.Social
 		}{
 			"Tweets - daffy.io",
 			user,
+			getFlashes(w, r, sessionStore, sessionName),
+			csrfToken(r),
 			socials,
 		}
 		render(w, tmpl, "my-tweet.html", data)
 	}
 }
 
-func MyTweetHandlerPost(sessionStore sessions.Store, sessionName string, boltStore *store.BoltStore, tmpl *template.Template) func(w http.ResponseWriter, r *http.Request) {
+func MyTweetHandlerPost(sessionStore sessions.Store, sessionName string, api store.Api, tmpl *template.Template) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer logfn.Exit(logfn.Enter("handlers.MyTweetHandlerPost"))
 
-		socialId := r.FormValue("SocialId")
 		tweet := r.FormValue("Tweet")
-		fmt.Printf("socialid=%#v\n", socialId)
-		fmt.Printf("tweet=%#v\n", tweet)
 
 		// ToDo: check that there is something in the tweet, and figure out the 140 char rules.
-		// ToDo: check that socialId is contained within the socials list (or actually, just check for ourselves in the store)
+
+		// tweet from the account they chose, as long as it is one of their own Twitter accounts
+		var social *types.Social
 		socials := middleware.GetSocials(r)
+		for i := range socials {
+			if socials[i].Id == r.FormValue("SocialId") && socials[i].Provider == "twitter" {
+				social = &socials[i]
+			}
+		}
+		if social == nil {
+			addFlash(r, sessionStore, sessionName, types.FlashError, "Please choose one of your Twitter accounts to tweet from.")
+			sessions.Save(r, w)
+			http.Redirect(w, r, "/my/tweet", http.StatusFound)
+			return
+		}
 
-		// let's post this tweet on behalf of the user
-		consumerKey := os.Getenv("DAFFY_TWITTER_CONSUMER_KEY")
-		consumerSecret := os.Getenv("DAFFY_TWITTER_CONSUMER_SECRET")
+		// let's post this tweet on behalf of the user, signed with the same app they logged in to
+		consumerKey, consumerSecret, err := provider.TwitterConsumer()
+		if err != nil {
+			errpage.Render(w, r, http.StatusInternalServerError, err)
+			return
+		}
 		consumer := oauth.NewConsumer(consumerKey, consumerSecret, oauth.ServiceProvider{})
 		// consumer.Debug(true)
 
 		// create the accessToken
 		accessToken := &oauth.AccessToken{
-			Token:  socials[0].AccessToken,
-			Secret: socials[0].AccessTokenSecret,
+			Token:  social.AccessToken,
+			Secret: social.AccessTokenSecret,
 		}
 		twitterEndPoint := "https://api.twitter.com/1.1/statuses/update.json"
 		params := make(map[string]string)
 		params["status"] = tweet
 		response, err := consumer.Post(twitterEndPoint, params, accessToken)
 		if err != nil {
-			log.Print(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			// if Twitter said no, tell the user why, otherwise it's for us to look into
+			requestId := errpage.Log(r, http.StatusBadGateway, err)
+			message, ok := twitterErrorMessage(err)
+			if !ok {
+				message = "something went wrong, please try again later (request " + requestId + ")"
+			}
+			addFlash(r, sessionStore, sessionName, types.FlashError, "Your tweet wasn't posted: "+message)
+			sessions.Save(r, w)
+			http.Redirect(w, r, "/my/", http.StatusFound)
 			return
 		}
 		defer response.Body.Close()
@@ -75,12 +108,75 @@ func MyTweetHandlerPost(sessionStore sessions.Store, sessionName string, boltSto
 		// get the response and perhaps tell the user about it
 		respBody, err := ioutil.ReadAll(response.Body)
 		if err != nil {
-			log.Print(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			errpage.Render(w, r, http.StatusInternalServerError, err)
 			return
 		}
-		fmt.Println(string(respBody))
+
+		// keep a record of the tweet so it shows up in the user's history
+		id, statusURL, err := twitterStatus(respBody)
+		if err != nil {
+			log.Print(err)
+		}
+		user := getUser(r)
+		post := types.Post{
+			SocialId:   social.Id,
+			ProviderId: id,
+			Text:       tweet,
+		}
+		if _, err := api.InsPost(*user, post); err != nil {
+			log.Print(err)
+		}
+
+		// and tell the user where to find it
+		switch {
+		case statusURL != "":
+			addFlash(r, sessionStore, sessionName, types.FlashSuccess, "Your tweet has been posted: "+statusURL)
+		case id != "":
+			addFlash(r, sessionStore, sessionName, types.FlashSuccess, "Your tweet has been posted with id "+id+".")
+		default:
+			addFlash(r, sessionStore, sessionName, types.FlashSuccess, "Your tweet has been posted.")
+		}
+		sessions.Save(r, w)
 
 		http.Redirect(w, r, "/my/", http.StatusFound)
 	}
 }
+
+// twitterStatus reads the id of the new tweet out of Twitter's response, along with its URL if we know who posted it.
+func twitterStatus(respBody []byte) (string, string, error) {
+	var status struct {
+		IdStr string `json:"id_str"`
+		User  struct {
+			ScreenName string `json:"screen_name"`
+		} `json:"user"`
+	}
+	err := json.Unmarshal(respBody, &status)
+	if err != nil {
+		return "", "", err
+	}
+	if status.IdStr == "" || status.User.ScreenName == "" {
+		return status.IdStr, "", nil
+	}
+	return status.IdStr, "https://twitter.com/" + url.PathEscape(status.User.ScreenName) + "/status/" + status.IdStr, nil
+}
+
+// twitterErrorMessage pulls the first message out of an error response from Twitter, which looks like
+// `{"errors":[{"code":187,"message":"Status is a duplicate."}]}`, falling back to the HTTP status. It returns false if
+// err didn't come from Twitter at all, since then it's one of ours and not for the user to see.
+func twitterErrorMessage(err error) (string, bool) {
+	httpErr, ok := err.(oauth.HTTPExecuteError)
+	if !ok {
+		return "", false
+	}
+
+	var body struct {
+		Errors []struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if json.Unmarshal(httpErr.ResponseBodyBytes, &body) != nil || len(body.Errors) == 0 {
+		return httpErr.Status, true
+	}
+	return body.Errors[0].Message, true
+}
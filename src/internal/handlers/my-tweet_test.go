@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/markbates/goth"
+	"github.com/mrjones/oauth"
+
+	"internal/middleware"
+	"internal/types"
+)
+
+func TestTwitterErrorMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		message string
+		ok      bool
+	}{
+		{"Twitter's message", oauth.HTTPExecuteError{Status: "403 Forbidden", ResponseBodyBytes: []byte(`{"errors":[{"code":187,"message":"Status is a duplicate."}]}`)}, "Status is a duplicate.", true},
+		{"no message", oauth.HTTPExecuteError{Status: "503 Service Unavailable", ResponseBodyBytes: []byte(`<html>Over capacity</html>`)}, "503 Service Unavailable", true},
+		{"not from Twitter", errors.New("dial tcp 10.0.0.1:443: i/o timeout"), "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			message, ok := twitterErrorMessage(test.err)
+			if message != test.message || ok != test.ok {
+				t.Errorf("twitterErrorMessage() = %q, %v, want %q, %v", message, ok, test.message, test.ok)
+			}
+		})
+	}
+}
+
+func TestMyTweetHandlerPostSocial(t *testing.T) {
+	goth.ClearProviders()
+
+	api := newApiStore(t)
+	user := mustLogIn(t, api, "twitter", "1", "alice")
+	user, err := api.LogIn(user.Id, types.SocialLogIn{Provider: "github", Id: "2", NickName: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := mustLogIn(t, api, "twitter", "3", "bob")
+	secret := mustInsToken(t, api, user, types.TokenScopeRead, types.TokenScopeWrite)
+	sessionStore := sessions.NewCookieStore([]byte("0123456789abcdef0123456789abcdef"))
+	handler := middleware.CheckToken(api)(middleware.LoadSocials(api)(http.HandlerFunc(MyTweetHandlerPost(sessionStore, "session", api, nil))))
+
+	tests := []struct {
+		name     string
+		socialId string
+		status   int
+		location string
+	}{
+		{"no account", "", http.StatusFound, "/my/tweet"},
+		{"not Twitter", "github:2", http.StatusFound, "/my/tweet"},
+		{"someone else's", other.SocialIds[0], http.StatusFound, "/my/tweet"},
+		// this one gets as far as signing the tweet, but there's no twitter provider to sign it with
+		{"their Twitter", "twitter:1", http.StatusInternalServerError, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			form := url.Values{"SocialId": {test.socialId}, "Tweet": {"Hello"}}
+			r := httptest.NewRequest(http.MethodPost, "/my/tweet", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.Header.Set("Authorization", "Bearer "+secret)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+
+			if rec.Code != test.status || rec.Header().Get("Location") != test.location {
+				t.Errorf("POST = %d to %q, want %d to %q", rec.Code, rec.Header().Get("Location"), test.status, test.location)
+			}
+		})
+	}
+}
@@ -1,114 +1,166 @@
 package handlers
 
 import (
-	"fmt"
 	"html/template"
-	"log"
 	"net/http"
 
 	"github.com/chilts/logfn"
+	"github.com/gomiddleware/mux"
 	"github.com/gorilla/sessions"
 
+	"internal/errpage"
+	"internal/provider"
 	"internal/store"
 	"internal/types"
 )
 
-func SettingsProfileHandler(sessionStore sessions.Store, sessionName string, boltStore *store.BoltStore) func(w http.ResponseWriter, r *http.Request) {
+func SettingsProfileHandler(sessionStore sessions.Store, sessionName string, providers []provider.Provider, api store.Api, tmpl *template.Template) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer logfn.Exit(logfn.Enter("settingsProfileHandler"))
 
-		user := getUserFromSession(r, sessionStore, sessionName)
+		user := getUser(r)
 
-		// parse the incoming form
-		err := r.ParseForm()
+		// decode and validate the incoming form into a types.UpdateUser
+		updateUser := types.UpdateUser{}
+		errs, err := decodeForm(r, &updateUser)
 		if err != nil {
-			log.Print(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			errpage.Render(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if errs != nil {
+			renderSettings(w, r, providers, api, tmpl, user, nil, updateUser, errs)
 			return
 		}
 
-		// decode the form into a types.UpdateUser
-		updateUser := types.UpdateUser{}
-		err = decoder.Decode(&updateUser, r.PostForm)
-		// check if this errors is from `govalidator` rather than any other general kind of error
+		// update this user
+		_, err = api.UpdateUser(*user, updateUser)
+		if err == store.ErrUsernameAlreadyExists {
+			renderSettings(w, r, providers, api, tmpl, user, nil, updateUser, formErrors{"userName": "Sorry, that username is already taken"})
+			return
+		}
 		if err != nil {
-			log.Print(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			errpage.Render(w, r, http.StatusInternalServerError, err)
 			return
 		}
 
-		// tell the API what to update
-		fmt.Printf("updateUser=%#v\n", updateUser)
+		addFlash(r, sessionStore, sessionName, types.FlashSuccess, "Your profile has been saved.")
+		sessions.Save(r, w)
 
-		// update this user
-		newUser, err := boltStore.UpdateUser(*user, updateUser)
+		http.Redirect(w, r, "/", http.StatusFound)
+	}
+}
+
+func SettingsSocialUnlinkHandler(sessionStore sessions.Store, sessionName string, api store.Api) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer logfn.Exit(logfn.Enter("settingsSocialUnlinkHandler"))
+
+		user := getUser(r)
+
+		vals := mux.Vals(r)
+		socialId := vals["id"]
+
+		_, err := api.UnlinkSocial(*user, socialId)
+		if err == store.ErrSocialUnknown {
+			errpage.NotFound(w, r)
+			return
+		}
+		if err == store.ErrLastSocial {
+			addFlash(r, sessionStore, sessionName, types.FlashWarning, err.Error()+".")
+			sessions.Save(r, w)
+			http.Redirect(w, r, "/settings/", http.StatusFound)
+			return
+		}
 		if err != nil {
-			log.Print(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			errpage.Render(w, r, http.StatusInternalServerError, err)
 			return
 		}
 
-		fmt.Printf("* NEW * user = %v\n", newUser)
+		addFlash(r, sessionStore, sessionName, types.FlashSuccess, "Unlinked "+socialId+".")
+		sessions.Save(r, w)
 
-		// save this new user
-		session, _ := sessionStore.Get(r, sessionName)
-		session.Values["user"] = &newUser
-		session.Save(r, w)
-
-		http.Redirect(w, r, "/", http.StatusFound)
+		http.Redirect(w, r, "/settings/", http.StatusFound)
 	}
 }
 
-func MyHandler(sessionStore sessions.Store, sessionName string, boltStore *store.BoltStore, tmpl *template.Template) func(w http.ResponseWriter, r *http.Request) {
+func MyHandler(sessionStore sessions.Store, sessionName string, api store.Api, tmpl *template.Template) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer logfn.Exit(logfn.Enter("myHandler"))
 
-		user := getUserFromSession(r, sessionStore, sessionName)
+		user := getUser(r)
 
 		// get all the social entities
-		socials, err := boltStore.SelSocials(user.SocialIds)
+		socials, err := api.SelSocials(user.SocialIds)
 		if err != nil {
-			log.Print(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			errpage.Render(w, r, http.StatusInternalServerError, err)
 			return
 		}
 
 		data := struct {
 			Title   string
 			User    *types.User
+			Flashes []types.Flash
+			CSRF    string
 			Socials []types.Social
 		}{
 			"My Daffy - daffy.io",
 			user,
+			getFlashes(w, r, sessionStore, sessionName),
+			csrfToken(r),
 			socials,
 		}
 		render(w, tmpl, "my-index.html", data)
 	}
 }
 
-func SettingsHandler(sessionStore sessions.Store, sessionName string, boltStore *store.BoltStore, tmpl *template.Template) func(w http.ResponseWriter, r *http.Request) {
+func SettingsHandler(sessionStore sessions.Store, sessionName string, providers []provider.Provider, api store.Api, tmpl *template.Template) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer logfn.Exit(logfn.Enter("settingsHandler"))
 
-		user := getUserFromSession(r, sessionStore, sessionName)
+		user := getUser(r)
 
-		// get all the social entities
-		socials, err := boltStore.SelSocials(user.SocialIds)
-		if err != nil {
-			log.Print(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+		// start the profile form off with what we already have
+		form := types.UpdateUser{
+			Name:  user.Name,
+			Title: user.Title,
+			Email: user.Email,
 		}
+		renderSettings(w, r, providers, api, tmpl, user, getFlashes(w, r, sessionStore, sessionName), form, nil)
+	}
+}
 
-		data := struct {
-			Title   string
-			User    *types.User
-			Socials []types.Social
-		}{
-			"Settings - daffy.io",
-			user,
-			socials,
-		}
-		render(w, tmpl, "settings-index.html", data)
+// renderSettings shows the settings page, with the profile form filled in with the values given. If there are any
+// errors then they are shown next to each field and the page is sent as a 400.
+func renderSettings(w http.ResponseWriter, r *http.Request, providers []provider.Provider, api store.Api, tmpl *template.Template, user *types.User, flashes []types.Flash, form types.UpdateUser, errs formErrors) {
+	// get all the social entities
+	socials, err := api.SelSocials(user.SocialIds)
+	if err != nil {
+		errpage.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	data := struct {
+		Title     string
+		User      *types.User
+		Flashes   []types.Flash
+		CSRF      string
+		Providers []provider.Provider
+		Socials   []types.Social
+		Form      types.UpdateUser
+		Errors    formErrors
+	}{
+		"Settings - daffy.io",
+		user,
+		flashes,
+		csrfToken(r),
+		providers,
+		socials,
+		form,
+		errs,
+	}
+
+	status := http.StatusOK
+	if errs != nil {
+		status = http.StatusBadRequest
 	}
+	renderStatus(w, tmpl, "settings-index.html", status, data)
 }
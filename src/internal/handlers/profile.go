@@ -1,50 +1,51 @@
 package handlers
 
 import (
-	"fmt"
 	"html/template"
-	"log"
 	"net/http"
 
 	"github.com/chilts/logfn"
 	"github.com/gomiddleware/mux"
 	"github.com/gorilla/sessions"
-	"github.com/markbates/goth"
 
+	"internal/errpage"
+	"internal/provider"
 	"internal/store"
 	"internal/types"
 )
 
-func ProfileHandler(sessionStore sessions.Store, sessionName string, providers goth.Providers, boltStore *store.BoltStore, tmpl *template.Template) func(w http.ResponseWriter, r *http.Request) {
+func ProfileHandler(sessionStore sessions.Store, sessionName string, providers []provider.Provider, api store.Api, tmpl *template.Template) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer logfn.Exit(logfn.Enter("UserHandler"))
 
-		user := getUserFromSession(r, sessionStore, sessionName)
+		user := getUser(r)
 
 		vals := mux.Vals(r)
-		fmt.Printf("username=%s\n", vals["username"])
 
 		// get this user from the store
-		profile, err := boltStore.GetUserPublic(vals["username"])
+		profile, err := api.GetUserPublic(vals["username"])
 		if err != nil {
-			log.Print(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			errpage.Render(w, r, http.StatusInternalServerError, err)
 			return
 		}
 
 		if profile == nil {
-			http.NotFound(w, r)
+			errpage.NotFound(w, r)
 			return
 		}
 
 		data := struct {
 			Title     string
 			User      *types.User
-			Providers goth.Providers
+			Flashes   []types.Flash
+			CSRF      string
+			Providers []provider.Provider
 			Profile   *types.User
 		}{
 			"User Profile - daffy.io",
 			user,
+			getFlashes(w, r, sessionStore, sessionName),
+			csrfToken(r),
 			providers,
 			profile,
 		}
@@ -3,16 +3,26 @@ package handlers
 import (
 	"bytes"
 	"html/template"
+	"log"
 	"net/http"
 )
 
 func render(w http.ResponseWriter, tmpl *template.Template, tmplName string, data interface{}) {
+	renderStatus(w, tmpl, tmplName, http.StatusOK, data)
+}
+
+// renderStatus is the same as render() but lets you send something other than a 200, e.g. a 400 when re-rendering a
+// form with errors.
+func renderStatus(w http.ResponseWriter, tmpl *template.Template, tmplName string, status int, data interface{}) {
 	buf := &bytes.Buffer{}
 	err := tmpl.ExecuteTemplate(buf, tmplName, data)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		// don't show the template error to the user
+		log.Printf("Error rendering %s: %s\n", tmplName, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
+	w.WriteHeader(status)
 	buf.WriteTo(w)
 }
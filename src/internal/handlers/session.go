@@ -6,22 +6,44 @@ import (
 	"github.com/chilts/logfn"
 	"github.com/gorilla/sessions"
 
+	"internal/errpage"
+	"internal/middleware"
 	"internal/sess"
+	"internal/store"
 	"internal/types"
 )
 
-func getUserFromSession(r *http.Request, sessionStore sessions.Store, sessionName string) *types.User {
-	return sess.GetUserFromSession(r, sessionStore, sessionName)
+// getUser gives the logged in user, as loaded fresh by middleware.LoadUser.
+func getUser(r *http.Request) *types.User {
+	return middleware.GetUser(r)
 }
 
-func LogoutHandler(sessionStore sessions.Store, sessionName string) func(w http.ResponseWriter, r *http.Request) {
+// csrfToken gives the token which must be put into every form, see `templates/mdl/csrf.html`.
+func csrfToken(r *http.Request) string {
+	return middleware.GetCSRFToken(r)
+}
+
+func addFlash(r *http.Request, sessionStore sessions.Store, sessionName, kind, message string) {
+	sess.AddFlash(r, sessionStore, sessionName, kind, message)
+}
+
+func getFlashes(w http.ResponseWriter, r *http.Request, sessionStore sessions.Store, sessionName string) []types.Flash {
+	return sess.GetFlashes(w, r, sessionStore, sessionName)
+}
+
+func LogoutHandler(sessionStore sessions.Store, sessionName string, api store.Api) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer logfn.Exit(logfn.Enter("logoutHandler"))
 
 		session, _ := sessionStore.Get(r, sessionName)
 
-		// scrub user
-		delete(session.Values, "user")
+		// scrub user, and forget this session so the cookie can't be used again
+		err := sess.LogOut(r, sessionStore, sessionName, api)
+		if err != nil {
+			errpage.Render(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		addFlash(r, sessionStore, sessionName, types.FlashInfo, "You have been logged out.")
 		session.Save(r, w)
 
 		// redirect to somewhere else
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/chilts/logfn"
+	"github.com/gorilla/sessions"
+
+	"internal/errpage"
+	"internal/store"
+	"internal/types"
+)
+
+func SettingsDeleteHandlerGet(sessionStore sessions.Store, sessionName string, gracePeriod time.Duration, tmpl *template.Template) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer logfn.Exit(logfn.Enter("handlers.SettingsDeleteHandlerGet"))
+
+		user := getUser(r)
+
+		data := struct {
+			Title       string
+			User        *types.User
+			Flashes     []types.Flash
+			CSRF        string
+			GracePeriod time.Duration
+		}{
+			"Delete Account - daffy.io",
+			user,
+			getFlashes(w, r, sessionStore, sessionName),
+			csrfToken(r),
+			gracePeriod,
+		}
+		render(w, tmpl, "settings-delete.html", data)
+	}
+}
+
+func SettingsDeleteHandlerPost(sessionStore sessions.Store, sessionName string, api store.Api, gracePeriod time.Duration) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer logfn.Exit(logfn.Enter("handlers.SettingsDeleteHandlerPost"))
+
+		user := getUser(r)
+
+		// the user must confirm by typing in their username
+		if r.FormValue("userName") != user.Name {
+			errpage.Message(w, r, http.StatusBadRequest, "Please type your username to confirm you want to delete your account.")
+			return
+		}
+
+		var err error
+		if gracePeriod > 0 {
+			_, err = api.ScheduleDelUser(*user, time.Now().Add(gracePeriod))
+			if err == nil {
+				// log them out everywhere, since they have to log in again to cancel it
+				err = api.DelSessions(*user, "")
+			}
+		} else {
+			err = api.DelUser(*user)
+		}
+		if err != nil {
+			errpage.Render(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		// scrub user and expire the session cookie itself
+		session, _ := sessionStore.Get(r, sessionName)
+		delete(session.Values, "uid")
+		delete(session.Values, "sid")
+		session.Options.MaxAge = -1
+		session.Save(r, w)
+
+		http.Redirect(w, r, "/", http.StatusFound)
+	}
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/chilts/logfn"
+
+	"internal/errpage"
+	"internal/store"
+	"internal/types"
+)
+
+// SettingsExportHandler sends the user a zip file of everything we hold about them. Each record is in there as JSON,
+// along with an `index.html` summary they can open in a browser. All tokens are removed from the socials, and the
+// personal access tokens and sessions are redacted.
+func SettingsExportHandler(api store.Api, tmpl *template.Template) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer logfn.Exit(logfn.Enter("handlers.SettingsExportHandler"))
+
+		user := getUser(r)
+
+		socials, err := api.SelSocials(user.SocialIds)
+		if err != nil {
+			errpage.Render(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		for i := range socials {
+			socials[i] = socials[i].Redacted()
+		}
+
+		posts, err := api.SelPosts(user.Id)
+		if err != nil {
+			errpage.Render(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		events, err := api.SelEvents(user.Id)
+		if err != nil {
+			errpage.Render(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		sessions, err := api.SelSessions(user.Id)
+		if err != nil {
+			errpage.Render(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		for i := range sessions {
+			sessions[i] = sessions[i].Redacted()
+		}
+
+		tokens, err := api.SelTokens(user.Id)
+		if err != nil {
+			errpage.Render(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		for i := range tokens {
+			tokens[i] = tokens[i].Redacted()
+		}
+
+		now := time.Now().UTC()
+		data := struct {
+			Title    string
+			User     *types.User
+			Socials  []types.Social
+			Posts    []types.Post
+			Events   []types.Event
+			Sessions []types.Session
+			Tokens   []types.Token
+			Exported time.Time
+		}{
+			"Your Data - daffy.io",
+			user,
+			socials,
+			posts,
+			events,
+			sessions,
+			tokens,
+			now,
+		}
+
+		buf := &bytes.Buffer{}
+		zw := zip.NewWriter(buf)
+		files := []struct {
+			name string
+			data interface{}
+		}{
+			{"user.json", user},
+			{"socials.json", socials},
+			{"posts.json", posts},
+			{"events.json", events},
+			{"sessions.json", sessions},
+			{"tokens.json", tokens},
+		}
+		for _, file := range files {
+			err = writeZipJson(zw, file.name, file.data, now)
+			if err != nil {
+				errpage.Render(w, r, http.StatusInternalServerError, err)
+				return
+			}
+		}
+
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: "index.html", Method: zip.Deflate, Modified: now})
+		if err == nil {
+			err = tmpl.ExecuteTemplate(f, "takeout.html", data)
+		}
+		if err == nil {
+			err = zw.Close()
+		}
+		if err != nil {
+			errpage.Render(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		filename := fmt.Sprintf("daffy-%s-%s.zip", user.Name, now.Format("20060102"))
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		w.Header().Set("Cache-Control", "no-store")
+		buf.WriteTo(w)
+	}
+}
+
+func writeZipJson(zw *zip.Writer, name string, v interface{}, modified time.Time) error {
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strings"
+	"testing"
+
+	"internal/types"
+)
+
+func TestSettingsExport(t *testing.T) {
+	api := newApiStore(t)
+	user := mustLogIn(t, api, "twitter", "1", "alice")
+	secret := mustInsToken(t, api, user, types.TokenScopeRead)
+	session, err := api.InsSession(user.Id, types.Session{UserAgent: "Mozilla/5.0", IP: "203.0.113.7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := template.Must(template.New("takeout.html").Parse(`{{ len .Sessions }} {{ len .Tokens }}`))
+	rec := serveApi(api, SettingsExportHandler(api, tmpl), http.MethodGet, secret, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := &bytes.Buffer{}
+		buf.ReadFrom(rc)
+		rc.Close()
+		files[f.Name] = buf.Bytes()
+	}
+
+	if string(files["index.html"]) != "1 1" {
+		t.Errorf("index.html = %q, want one session and one token", files["index.html"])
+	}
+
+	var sessions []types.Session
+	if err := json.Unmarshal(files["sessions.json"], &sessions); err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].IP != "203.0.113.7" || sessions[0].UserAgent != "Mozilla/5.0" || sessions[0].Inserted.IsZero() {
+		t.Errorf("sessions.json = %s", files["sessions.json"])
+	}
+	if bytes.Contains(files["sessions.json"], []byte(session.Id)) {
+		t.Errorf("sessions.json contains the session's Id")
+	}
+
+	var tokens []types.Token
+	if err := json.Unmarshal(files["tokens.json"], &tokens); err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 || tokens[0].Name != "test" || tokens[0].Hash != "" {
+		t.Errorf("tokens.json = %s", files["tokens.json"])
+	}
+
+	// and no secrets anywhere
+	for name, data := range files {
+		for _, s := range []string{secret, "access-token-of-alice", "access-secret-of-alice"} {
+			if strings.Contains(string(data), s) {
+				t.Errorf("%s contains the secret %q", name, s)
+			}
+		}
+	}
+}
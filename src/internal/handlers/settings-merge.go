@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/chilts/logfn"
+	"github.com/gorilla/sessions"
+
+	"internal/errpage"
+	"internal/store"
+	"internal/types"
+)
+
+// mergeTimeout is how long the user has to finish a merge after proving they own the other account.
+const mergeTimeout = 15 * time.Minute
+
+func setMergeUserId(session *sessions.Session, userId string) {
+	session.Values["merge"] = userId
+	session.Values["merge-expires"] = time.Now().Add(mergeTimeout).Unix()
+}
+
+// getMergeUserId returns the other user which has been proven to be owned by the current user, if it hasn't expired.
+func getMergeUserId(session *sessions.Session) string {
+	userId, _ := session.Values["merge"].(string)
+	expires, _ := session.Values["merge-expires"].(int64)
+	if userId == "" || time.Now().Unix() > expires {
+		return ""
+	}
+	return userId
+}
+
+func clearMergeUserId(session *sessions.Session) {
+	delete(session.Values, "merge")
+	delete(session.Values, "merge-expires")
+}
+
+func SettingsMergeHandlerGet(sessionStore sessions.Store, sessionName string, api store.Api, tmpl *template.Template) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer logfn.Exit(logfn.Enter("handlers.SettingsMergeHandlerGet"))
+
+		user := getUser(r)
+		session, _ := sessionStore.Get(r, sessionName)
+
+		otherUserId := getMergeUserId(session)
+		if otherUserId == "" {
+			http.Redirect(w, r, "/settings/", http.StatusFound)
+			return
+		}
+
+		other, err := api.GetUser(otherUserId)
+		if err != nil {
+			errpage.Render(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		if other == nil {
+			http.Redirect(w, r, "/settings/", http.StatusFound)
+			return
+		}
+
+		data := struct {
+			Title   string
+			User    *types.User
+			Flashes []types.Flash
+			CSRF    string
+			Other   *types.User
+		}{
+			"Merge Accounts - daffy.io",
+			user,
+			getFlashes(w, r, sessionStore, sessionName),
+			csrfToken(r),
+			other,
+		}
+		render(w, tmpl, "settings-merge.html", data)
+	}
+}
+
+func SettingsMergeHandlerPost(sessionStore sessions.Store, sessionName string, api store.Api) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer logfn.Exit(logfn.Enter("handlers.SettingsMergeHandlerPost"))
+
+		user := getUser(r)
+		session, _ := sessionStore.Get(r, sessionName)
+
+		otherUserId := getMergeUserId(session)
+		if otherUserId == "" {
+			http.Redirect(w, r, "/settings/", http.StatusFound)
+			return
+		}
+
+		// parse the incoming form
+		err := r.ParseForm()
+		if err != nil {
+			errpage.Render(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		// decode the form into a types.MergeUser
+		keep := types.MergeUser{}
+		err = decoder.Decode(&keep, postForm(r))
+		if err != nil {
+			errpage.Render(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		_, err = api.MergeUsers(*user, otherUserId, keep)
+		if err == store.ErrMergeInvalid {
+			errpage.Message(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err != nil {
+			errpage.Render(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		clearMergeUserId(session)
+		addFlash(r, sessionStore, sessionName, types.FlashSuccess, "Your accounts have been merged.")
+		session.Save(r, w)
+
+		http.Redirect(w, r, "/settings/", http.StatusFound)
+	}
+}
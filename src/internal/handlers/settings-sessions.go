@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/chilts/logfn"
+	"github.com/gomiddleware/mux"
+	"github.com/gorilla/sessions"
+
+	"internal/errpage"
+	"internal/sess"
+	"internal/store"
+	"internal/types"
+)
+
+// SettingsSessionsHandler lists everywhere the user is logged in, most recently used first.
+func SettingsSessionsHandler(sessionStore sessions.Store, sessionName string, api store.Api, tmpl *template.Template) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer logfn.Exit(logfn.Enter("handlers.SettingsSessionsHandler"))
+
+		user := getUser(r)
+
+		list, err := api.SelSessions(user.Id)
+		if err != nil {
+			errpage.Render(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		data := struct {
+			Title     string
+			User      *types.User
+			Flashes   []types.Flash
+			CSRF      string
+			Sessions  []types.Session
+			CurrentId string
+		}{
+			"Sessions - daffy.io",
+			user,
+			getFlashes(w, r, sessionStore, sessionName),
+			csrfToken(r),
+			list,
+			sess.GetSessionId(r, sessionStore, sessionName),
+		}
+		render(w, tmpl, "settings-sessions.html", data)
+	}
+}
+
+// SettingsSessionRevokeHandler logs out one session. Revoking the one you're using is the same as logging out.
+func SettingsSessionRevokeHandler(sessionStore sessions.Store, sessionName string, api store.Api) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer logfn.Exit(logfn.Enter("handlers.SettingsSessionRevokeHandler"))
+
+		user := getUser(r)
+
+		vals := mux.Vals(r)
+		sessionId := vals["id"]
+
+		if sessionId == sess.GetSessionId(r, sessionStore, sessionName) {
+			err := sess.LogOut(r, sessionStore, sessionName, api)
+			if err != nil {
+				errpage.Render(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			addFlash(r, sessionStore, sessionName, types.FlashInfo, "You have been logged out.")
+			sessions.Save(r, w)
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+
+		err := api.DelSession(*user, sessionId)
+		if err == store.ErrSessionUnknown {
+			errpage.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			errpage.Render(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		addFlash(r, sessionStore, sessionName, types.FlashSuccess, "That session has been logged out.")
+		sessions.Save(r, w)
+
+		http.Redirect(w, r, "/settings/sessions", http.StatusFound)
+	}
+}
+
+// SettingsSessionsRevokeAllHandler logs out every session apart from the one being used.
+func SettingsSessionsRevokeAllHandler(sessionStore sessions.Store, sessionName string, api store.Api) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer logfn.Exit(logfn.Enter("handlers.SettingsSessionsRevokeAllHandler"))
+
+		user := getUser(r)
+
+		err := api.DelSessions(*user, sess.GetSessionId(r, sessionStore, sessionName))
+		if err != nil {
+			errpage.Render(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		addFlash(r, sessionStore, sessionName, types.FlashSuccess, "You have been logged out everywhere else.")
+		sessions.Save(r, w)
+
+		http.Redirect(w, r, "/settings/sessions", http.StatusFound)
+	}
+}
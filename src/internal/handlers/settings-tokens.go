@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/chilts/logfn"
+	"github.com/gomiddleware/mux"
+	"github.com/gorilla/sessions"
+
+	"internal/errpage"
+	"internal/store"
+	"internal/types"
+)
+
+func SettingsTokensHandlerGet(sessionStore sessions.Store, sessionName string, api store.Api, tmpl *template.Template) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer logfn.Exit(logfn.Enter("handlers.SettingsTokensHandlerGet"))
+
+		user := getUser(r)
+
+		form := types.NewToken{
+			Scopes: []string{types.TokenScopeRead},
+		}
+		renderTokens(w, r, api, tmpl, user, getFlashes(w, r, sessionStore, sessionName), form, nil, "")
+	}
+}
+
+// SettingsTokensHandlerPost creates a new token and shows the page again with the secret on it. This is the only time
+// the user will ever see it.
+func SettingsTokensHandlerPost(api store.Api, tmpl *template.Template) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer logfn.Exit(logfn.Enter("handlers.SettingsTokensHandlerPost"))
+
+		user := getUser(r)
+
+		form := types.NewToken{}
+		errs, err := decodeForm(r, &form)
+		if err != nil {
+			errpage.Render(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if errs != nil {
+			renderTokens(w, r, api, tmpl, user, nil, form, errs, "")
+			return
+		}
+
+		token, secret, err := api.InsToken(*user, types.Token{Name: form.Name, Scopes: form.Scopes})
+		if err != nil {
+			errpage.Render(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		flashes := []types.Flash{{Kind: types.FlashSuccess, Message: "Your token '" + token.Name + "' has been created."}}
+		renderTokens(w, r, api, tmpl, user, flashes, types.NewToken{Scopes: []string{types.TokenScopeRead}}, nil, secret)
+	}
+}
+
+func SettingsTokenRevokeHandler(sessionStore sessions.Store, sessionName string, api store.Api) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer logfn.Exit(logfn.Enter("handlers.SettingsTokenRevokeHandler"))
+
+		user := getUser(r)
+
+		vals := mux.Vals(r)
+		tokenId := vals["id"]
+
+		err := api.DelToken(*user, tokenId)
+		if err == store.ErrTokenUnknown {
+			errpage.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			errpage.Render(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		addFlash(r, sessionStore, sessionName, types.FlashSuccess, "The token has been revoked.")
+		sessions.Save(r, w)
+
+		http.Redirect(w, r, "/settings/tokens", http.StatusFound)
+	}
+}
+
+// renderTokens shows the user's tokens along with the form to create a new one. If a token has just been created then
+// its secret is shown at the top.
+func renderTokens(w http.ResponseWriter, r *http.Request, api store.Api, tmpl *template.Template, user *types.User, flashes []types.Flash, form types.NewToken, errs formErrors, secret string) {
+	tokens, err := api.SelTokens(user.Id)
+	if err != nil {
+		errpage.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	scopes := make(map[string]bool)
+	for _, scope := range form.Scopes {
+		scopes[scope] = true
+	}
+
+	data := struct {
+		Title   string
+		User    *types.User
+		Flashes []types.Flash
+		CSRF    string
+		Tokens  []types.Token
+		Form    types.NewToken
+		Scopes  map[string]bool
+		Errors  formErrors
+		Secret  string
+	}{
+		"Access Tokens - daffy.io",
+		user,
+		flashes,
+		csrfToken(r),
+		tokens,
+		form,
+		scopes,
+		errs,
+		secret,
+	}
+
+	status := http.StatusOK
+	if errs != nil {
+		status = http.StatusBadRequest
+	}
+	renderStatus(w, tmpl, "settings-tokens.html", status, data)
+}
@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/chilts/logfn"
+
+	"internal/errpage"
+	"internal/store"
+	"internal/types"
+)
+
+const tokenKey key = 44
+
+// CheckToken looks for a personal access token in an `Authorization: Bearer ...` header and, if it is valid, puts the
+// user and token into the context in place of any user LoadUser found in the session. Requests without the header are
+// left alone so they can fall back to the session, but a bad token is always a 401 (in JSON, since this is only used
+// for the API).
+func CheckToken(api store.Api) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			defer logfn.Exit(logfn.Enter("middleware.CheckToken"))
+
+			header := r.Header.Get("Authorization")
+			if header == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			secret := strings.TrimPrefix(header, "Bearer ")
+			if secret == header || secret == "" {
+				sendJsonError(w, http.StatusUnauthorized, "The Authorization header must be 'Bearer <token>'")
+				return
+			}
+
+			user, token, err := api.GetUserByToken(secret)
+			if err != nil {
+				requestId := errpage.Log(r, http.StatusInternalServerError, err)
+				sendJsonError(w, http.StatusInternalServerError, "Internal Server Error (request "+requestId+")")
+				return
+			}
+			if user == nil {
+				sendJsonError(w, http.StatusUnauthorized, "Invalid token")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), userKey, user)
+			ctx = context.WithValue(ctx, tokenKey, token)
+
+			// serve the next middleware
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// GetToken gives the token the user authenticated with, or nil if they didn't use one.
+func GetToken(r *http.Request) *types.Token {
+	token, _ := r.Context().Value(tokenKey).(*types.Token)
+	return token
+}
+
+// sendJsonError sends the same shape of error document as the rest of the API.
+func sendJsonError(w http.ResponseWriter, status int, message string) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="daffy.io", error="invalid_token"`)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"status":  status,
+			"message": message,
+		},
+	})
+}
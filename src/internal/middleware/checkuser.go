@@ -7,30 +7,23 @@ import (
 	"github.com/chilts/logfn"
 	"github.com/gorilla/sessions"
 
-	"internal/types"
+	"internal/sess"
 )
 
+// CheckUser only lets logged in users through, so it must come after LoadUser. Everyone else is sent to the homepage,
+// and the page they wanted is remembered so they can be sent back there once they've logged in.
 func CheckUser(sessionStore sessions.Store, sessionName string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
 			defer logfn.Exit(logfn.Enter("main.checkUser"))
 
-			// get the session
-			session, _ := sessionStore.Get(r, sessionName)
-
-			// now check that the "user" key exists
-			value, ok := session.Values["user"]
-			if !ok {
-				log.Println("main.checkUser(): no user key in session")
-				// no "user" key
-				http.Redirect(w, r, "/", http.StatusFound)
-				return
-			}
-
-			// assert the value is user
-			_, ok = value.(*types.User)
-			if !ok {
-				log.Println("main.checkUser(): user key in session can't assert to a valid user")
+			if GetUser(r) == nil {
+				log.Println("main.checkUser(): no user is logged in")
+				// there's no sensible way to carry on with a form post, so only remember pages
+				if isSafeMethod(r.Method) {
+					sess.SetNext(r, sessionStore, sessionName, r.URL.RequestURI())
+					sessions.Save(r, w)
+				}
 				http.Redirect(w, r, "/", http.StatusFound)
 				return
 			}
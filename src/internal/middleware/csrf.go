@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/chilts/logfn"
+	"github.com/gorilla/sessions"
+
+	"internal/errpage"
+)
+
+const csrfKey key = 45
+
+// CSRFFieldName is the name of the hidden form field which carries the token, see `templates/mdl/csrf.html`.
+const CSRFFieldName = "csrf"
+
+// CSRFHeaderName is where scripts using the API with the session cookie send the token instead.
+const CSRFHeaderName = "X-CSRF-Token"
+
+// CSRF protects every state-changing request (i.e. anything other than GET, HEAD or OPTIONS) from cross-site forgery.
+// Each session gets a random token which is put into every form, and a request is only let through if it sends the
+// same token back, either in the form or in the `X-CSRF-Token` header. Another site can make the browser post to us
+// with our cookie, but it can't read the token.
+//
+// API requests with a bearer token are left alone, since a browser never adds one of those by itself and CheckToken
+// then authenticates them with the token rather than the cookie. Anywhere else the header is ignored, since the
+// cookie is all that counts there.
+func CSRF(sessionStore sessions.Store, sessionName string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			defer logfn.Exit(logfn.Enter("middleware.CSRF"))
+
+			session, _ := sessionStore.Get(r, sessionName)
+
+			// every session gets a token the first time we see it
+			token, _ := session.Values[CSRFFieldName].(string)
+			if token == "" {
+				var err error
+				token, err = newCSRFToken()
+				if err != nil {
+					errpage.Render(w, r, http.StatusInternalServerError, err)
+					return
+				}
+				session.Values[CSRFFieldName] = token
+				session.Save(r, w)
+			}
+
+			if !isSafeMethod(r.Method) && !isApiBearer(r) {
+				sent := r.Header.Get(CSRFHeaderName)
+				if sent == "" {
+					sent = r.PostFormValue(CSRFFieldName)
+				}
+				if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+					if strings.HasPrefix(r.URL.Path, "/api/") {
+						sendJsonError(w, http.StatusForbidden, "Missing or invalid "+CSRFHeaderName+" header")
+						return
+					}
+					errpage.Message(w, r, http.StatusForbidden, "This form has expired or didn't come from daffy.io. Please go back, reload the page and try again.")
+					return
+				}
+			}
+
+			// store this in the context
+			ctx := context.WithValue(r.Context(), csrfKey, token)
+
+			// serve the next middleware
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// GetCSRFToken gives the token to put into any forms on the page.
+func GetCSRFToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfKey).(string)
+	return token
+}
+
+func newCSRFToken() (string, error) {
+	random := make([]byte, 32)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(random), nil
+}
+
+// isApiBearer tells you if this is an API request which CheckToken will authenticate with a bearer token.
+func isApiBearer(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/api/") && strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
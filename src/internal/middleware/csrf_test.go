@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/sessions"
+)
+
+// newCSRFTest gives a handler behind CSRF, plus a session cookie and the token which goes with it.
+func newCSRFTest(t *testing.T) (http.Handler, *http.Cookie, string) {
+	sessionStore := sessions.NewCookieStore([]byte("0123456789abcdef0123456789abcdef"))
+	handler := CSRF(sessionStore, "session")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(GetCSRFToken(r)))
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	res := rec.Result()
+	if rec.Code != http.StatusOK || len(res.Cookies()) != 1 {
+		t.Fatalf("first GET = %d with cookies %v", rec.Code, res.Cookies())
+	}
+	token := rec.Body.String()
+	if token == "" {
+		t.Fatal("no CSRF token in the context")
+	}
+
+	return handler, res.Cookies()[0], token
+}
+
+func TestCSRF(t *testing.T) {
+	handler, cookie, token := newCSRFTest(t)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		form   url.Values
+		header http.Header
+		status int
+	}{
+		{"GET without a token", http.MethodGet, "/settings/", nil, nil, http.StatusOK},
+		{"HEAD without a token", http.MethodHead, "/settings/", nil, nil, http.StatusOK},
+		{"OPTIONS without a token", http.MethodOptions, "/settings/", nil, nil, http.StatusOK},
+		{"POST without a token", http.MethodPost, "/settings/profile", url.Values{}, nil, http.StatusForbidden},
+		{"POST with the wrong token", http.MethodPost, "/settings/profile", url.Values{"csrf": {"wrong"}}, nil, http.StatusForbidden},
+		{"POST with the token", http.MethodPost, "/settings/profile", url.Values{"csrf": {token}}, nil, http.StatusOK},
+		{"POST with the token in the header", http.MethodPost, "/settings/profile", nil, http.Header{"X-Csrf-Token": {token}}, http.StatusOK},
+		{"DELETE without a token", http.MethodDelete, "/settings/sessions/1", nil, nil, http.StatusForbidden},
+		{"API PATCH without a token", http.MethodPatch, "/api/v1/me", nil, nil, http.StatusForbidden},
+		{"API PATCH with a bearer token", http.MethodPatch, "/api/v1/me", nil, http.Header{"Authorization": {"Bearer abc"}}, http.StatusOK},
+		{"POST with a bearer token outside the API", http.MethodPost, "/settings/profile", url.Values{}, http.Header{"Authorization": {"Bearer abc"}}, http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var r *http.Request
+			if test.form != nil {
+				r = httptest.NewRequest(test.method, test.path, strings.NewReader(test.form.Encode()))
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			} else {
+				r = httptest.NewRequest(test.method, test.path, nil)
+			}
+			for name, values := range test.header {
+				r.Header[name] = values
+			}
+			r.AddCookie(cookie)
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+			if rec.Code != test.status {
+				t.Errorf("status = %d, want %d: %s", rec.Code, test.status, rec.Body)
+			}
+		})
+	}
+}
+
+func TestCSRFWithoutSession(t *testing.T) {
+	handler, _, token := newCSRFTest(t)
+
+	// someone else's token is no good without their session
+	r := httptest.NewRequest(http.MethodPost, "/settings/profile", strings.NewReader(url.Values{"csrf": {token}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
@@ -2,31 +2,29 @@ package middleware
 
 import (
 	"context"
-	"log"
 	"net/http"
 
 	"github.com/chilts/logfn"
-	"github.com/gorilla/sessions"
 
-	"internal/sess"
+	"internal/errpage"
 	"internal/store"
 	"internal/types"
 )
 
 const socialsKey key = 42
 
-func LoadSocials(sessionStore sessions.Store, sessionName string, boltStore *store.BoltStore) func(http.Handler) http.Handler {
+// LoadSocials puts the logged in user's socials into the context, so it must come after LoadUser.
+func LoadSocials(api store.Api) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
 			defer logfn.Exit(logfn.Enter("handlers.CheckUser"))
 
-			user := sess.GetUserFromSession(r, sessionStore, sessionName)
+			user := GetUser(r)
 
 			// get all the social entities
-			socials, err := boltStore.SelSocials(user.SocialIds)
+			socials, err := api.SelSocials(user.SocialIds)
 			if err != nil {
-				log.Print(err)
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				errpage.Render(w, r, http.StatusInternalServerError, err)
 				return
 			}
 
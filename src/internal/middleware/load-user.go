@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/chilts/logfn"
+	"github.com/gorilla/sessions"
+
+	"internal/errpage"
+	"internal/sess"
+	"internal/store"
+	"internal/types"
+)
+
+const userKey key = 43
+
+// LoadUser reads the logged in user fresh from the store and puts them into the context, so every handler sees the
+// user as they are now rather than as they were when they logged in. If their session has been logged out from
+// somewhere else, or the user no longer exists, they are logged out here too and the request carries on without them.
+func LoadUser(sessionStore sessions.Store, sessionName string, api store.Api) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			defer logfn.Exit(logfn.Enter("middleware.LoadUser"))
+
+			userId := sess.GetUserId(r, sessionStore, sessionName)
+			if userId == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			user, err := loadUser(r, sessionStore, sessionName, api, userId)
+			if err != nil {
+				errpage.Render(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			if user == nil {
+				log.Printf("middleware.LoadUser(): logging out user %s\n", userId)
+				session, _ := sessionStore.Get(r, sessionName)
+				delete(session.Values, "uid")
+				delete(session.Values, "sid")
+				sess.AddFlash(r, sessionStore, sessionName, types.FlashInfo, "You have been logged out.")
+				session.Save(r, w)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// store this in the context
+			ctx := context.WithValue(r.Context(), userKey, user)
+
+			// serve the next middleware
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// GetUser gives the logged in user, put into the context by LoadUser (or CheckToken for the API), or nil if no-one is
+// logged in.
+func GetUser(r *http.Request) *types.User {
+	user, _ := r.Context().Value(userKey).(*types.User)
+	return user
+}
+
+// loadUser gives the user, but only if their session still exists and the user does too.
+func loadUser(r *http.Request, sessionStore sessions.Store, sessionName string, api store.Api, userId string) (*types.User, error) {
+	sessionId := sess.GetSessionId(r, sessionStore, sessionName)
+	if sessionId == "" {
+		return nil, nil
+	}
+
+	session, err := api.GetSession(sessionId)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil || session.UserId != userId {
+		return nil, nil
+	}
+
+	return api.GetUser(userId)
+}
@@ -0,0 +1,143 @@
+package provider
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/markbates/goth"
+	"golang.org/x/oauth2"
+)
+
+// DevName is the name of the development provider, which must only ever be used with dev log in on.
+const DevName = "dev"
+
+// DevLoginPath is our own page which stands in for the provider's log in page, see `templates/mdl/dev-login.html`.
+const DevLoginPath = "/auth/dev/login"
+
+// DevUser is someone to log in as.
+type DevUser struct {
+	Id       string
+	NickName string
+	Email    string
+}
+
+// DevUsers are offered on the log in form so there's no need to make someone up every time.
+var DevUsers = []DevUser{
+	{"1", "alice", "alice@example.com"},
+	{"2", "bob", "bob@example.com"},
+	{"3", "carol", "carol@example.com"},
+}
+
+// Dev is a goth.Provider for development and automated tests, where anyone can log in as anyone. Rather than going off
+// to another site, the user is sent to a form on this one which comes straight back to the usual callback with the
+// user's details, so everything from there on (checking the state, LogInGoth() and the session) is the real thing.
+type Dev struct{}
+
+// Make sure the Dev provider conforms to goth.Provider.
+var _ goth.Provider = &Dev{}
+
+// NewDev creates the development provider. Never use it in production.
+func NewDev() *Dev {
+	return &Dev{}
+}
+
+// UseDev tells goth about the development provider, giving back what to show in the templates.
+func UseDev() Provider {
+	goth.UseProviders(NewDev())
+	return Provider{Name: DevName, Title: "Dev"}
+}
+
+// Name is the name used to retrieve this provider later.
+func (p *Dev) Name() string {
+	return DevName
+}
+
+// Debug is a no-op.
+func (p *Dev) Debug(debug bool) {}
+
+// BeginAuth sends the user to our own log in form.
+func (p *Dev) BeginAuth(state string) (goth.Session, error) {
+	return &DevSession{
+		AuthURL: DevLoginPath + "?state=" + url.QueryEscape(state),
+	}, nil
+}
+
+// UnmarshalSession reads back a session saved with Marshal().
+func (p *Dev) UnmarshalSession(data string) (goth.Session, error) {
+	s := &DevSession{}
+	err := json.NewDecoder(strings.NewReader(data)).Decode(s)
+	return s, err
+}
+
+// FetchUser gives back whoever was entered in the form.
+func (p *Dev) FetchUser(session goth.Session) (goth.User, error) {
+	s := session.(*DevSession)
+	if s.UserID == "" {
+		return goth.User{}, errors.New("dev: no user has been chosen")
+	}
+
+	return goth.User{
+		Provider:    p.Name(),
+		UserID:      s.UserID,
+		NickName:    s.NickName,
+		Name:        s.NickName,
+		Email:       s.Email,
+		AccessToken: "dev-" + s.UserID,
+		RawData: map[string]interface{}{
+			"id":       s.UserID,
+			"nickname": s.NickName,
+			"email":    s.Email,
+		},
+	}, nil
+}
+
+// RefreshTokenAvailable is false, since there's nothing to refresh.
+func (p *Dev) RefreshTokenAvailable() bool {
+	return false
+}
+
+// RefreshToken isn't supported.
+func (p *Dev) RefreshToken(refreshToken string) (*oauth2.Token, error) {
+	return nil, errors.New("dev: refresh tokens are not supported")
+}
+
+// DevSession is what gothic keeps between showing the form and the callback.
+type DevSession struct {
+	AuthURL  string
+	UserID   string
+	NickName string
+	Email    string
+}
+
+// Make sure the DevSession conforms to goth.Session.
+var _ goth.Session = &DevSession{}
+
+// GetAuthURL gives the URL set by BeginAuth().
+func (s *DevSession) GetAuthURL() (string, error) {
+	if s.AuthURL == "" {
+		return "", errors.New("dev: an AuthURL has not been set")
+	}
+	return s.AuthURL, nil
+}
+
+// Authorize takes the user's details from the form.
+func (s *DevSession) Authorize(provider goth.Provider, params goth.Params) (string, error) {
+	s.UserID = strings.TrimSpace(params.Get("id"))
+	s.NickName = strings.TrimSpace(params.Get("nickname"))
+	s.Email = strings.TrimSpace(params.Get("email"))
+	if s.UserID == "" {
+		return "", errors.New("dev: a user id is required")
+	}
+	if s.NickName == "" {
+		s.NickName = s.UserID
+	}
+	return "dev-" + s.UserID, nil
+}
+
+// Marshal the session into a string.
+func (s *DevSession) Marshal() string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
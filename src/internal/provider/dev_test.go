@@ -0,0 +1,47 @@
+package provider
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestDev(t *testing.T) {
+	p := NewDev()
+
+	session, err := p.BeginAuth("the/state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := session.GetAuthURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authURL != DevLoginPath+"?state=the%2Fstate" {
+		t.Errorf("auth URL = %q", authURL)
+	}
+
+	session, err = p.UnmarshalSession(session.Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := session.Authorize(p, url.Values{"nickname": {"nobody"}}); err == nil {
+		t.Errorf("Authorize() without a user id succeeded")
+	}
+	if _, err := session.Authorize(p, url.Values{"id": {" 42 "}, "email": {"dev@example.com"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := p.FetchUser(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Provider != "dev" || user.UserID != "42" || user.NickName != "42" || user.Email != "dev@example.com" {
+		t.Errorf("FetchUser() = %#v", user)
+	}
+
+	// listing "dev" in the config is refused, since only DAFFY_DEV_LOGIN can turn it on
+	if _, err := New(Config{Name: "dev", ClientId: "x"}, ""); err == nil {
+		t.Errorf("New() created the dev provider")
+	}
+}
@@ -0,0 +1,263 @@
+package provider
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/markbates/goth"
+	"golang.org/x/oauth2"
+)
+
+// discoveryPath is added to an issuer to find its configuration, see
+// https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderConfig
+const discoveryPath = "/.well-known/openid-configuration"
+
+// defaultOpenIDScopes are asked for if the config doesn't say otherwise. Without "openid" it isn't OpenID Connect.
+var defaultOpenIDScopes = []string{"openid", "profile", "email"}
+
+// httpClient is used for discovery and the userinfo endpoint, so a slow provider can't hold a request up forever.
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// OpenIDConnect is a goth.Provider for any OpenID Connect identity provider, e.g. Google. Everything it needs to know
+// about the provider is read from its discovery document when it is created, and the user's details come from the
+// userinfo endpoint using the access token we got from the provider directly.
+type OpenIDConnect struct {
+	ClientKey   string
+	Secret      string
+	CallbackURL string
+	Issuer      string
+	UserInfoURL string
+
+	name   string
+	config *oauth2.Config
+}
+
+// Make sure the OpenIDConnect provider conforms to goth.Provider.
+var _ goth.Provider = &OpenIDConnect{}
+
+// openIDConfiguration is the part of the discovery document we use.
+type openIDConfiguration struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserInfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+// NewOpenIDConnect fetches the discovery document for the issuer (e.g. "https://accounts.google.com", or the full URL
+// of its `/.well-known/openid-configuration`) and sets up a provider called name.
+func NewOpenIDConnect(name, discoveryURL, clientKey, secret, callbackURL string, scopes ...string) (*OpenIDConnect, error) {
+	issuer := strings.TrimSuffix(strings.TrimSuffix(discoveryURL, discoveryPath), "/")
+	if issuer == "" {
+		return nil, errors.New("oidc: a discovery URL is required")
+	}
+
+	res, err := httpClient.Get(issuer + discoveryPath)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: fetching discovery document: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc: fetching discovery document: %s", res.Status)
+	}
+
+	var doc openIDConfiguration
+	err = json.NewDecoder(res.Body).Decode(&doc)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: decoding discovery document: %s", err)
+	}
+
+	// the spec says these must match exactly, otherwise someone else's document is pretending to be this issuer
+	if strings.TrimSuffix(doc.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("oidc: discovery document is for issuer %q, not %q", doc.Issuer, issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.UserInfoEndpoint == "" {
+		return nil, errors.New("oidc: discovery document is missing an authorization, token or userinfo endpoint")
+	}
+
+	if len(scopes) == 0 {
+		scopes = defaultOpenIDScopes
+	}
+	if !contains(scopes, "openid") {
+		scopes = append([]string{"openid"}, scopes...)
+	}
+
+	p := &OpenIDConnect{
+		ClientKey:   clientKey,
+		Secret:      secret,
+		CallbackURL: callbackURL,
+		Issuer:      doc.Issuer,
+		UserInfoURL: doc.UserInfoEndpoint,
+		name:        name,
+	}
+	p.config = &oauth2.Config{
+		ClientID:     clientKey,
+		ClientSecret: secret,
+		RedirectURL:  callbackURL,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  doc.AuthorizationEndpoint,
+			TokenURL: doc.TokenEndpoint,
+		},
+		Scopes: scopes,
+	}
+	return p, nil
+}
+
+// Name is the name used to retrieve this provider later.
+func (p *OpenIDConnect) Name() string {
+	return p.name
+}
+
+// Debug is a no-op.
+func (p *OpenIDConnect) Debug(debug bool) {}
+
+// BeginAuth gives a session holding the URL to send the user to.
+func (p *OpenIDConnect) BeginAuth(state string) (goth.Session, error) {
+	return &OpenIDConnectSession{
+		AuthURL: p.config.AuthCodeURL(state),
+	}, nil
+}
+
+// UnmarshalSession reads back a session saved with Marshal().
+func (p *OpenIDConnect) UnmarshalSession(data string) (goth.Session, error) {
+	s := &OpenIDConnectSession{}
+	err := json.NewDecoder(strings.NewReader(data)).Decode(s)
+	return s, err
+}
+
+// FetchUser asks the userinfo endpoint who the access token belongs to.
+func (p *OpenIDConnect) FetchUser(session goth.Session) (goth.User, error) {
+	s := session.(*OpenIDConnectSession)
+	user := goth.User{
+		Provider:     p.Name(),
+		AccessToken:  s.AccessToken,
+		RefreshToken: s.RefreshToken,
+		ExpiresAt:    s.ExpiresAt,
+	}
+	if s.AccessToken == "" {
+		return user, errors.New("oidc: the session has no access token")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, p.UserInfoURL, nil)
+	if err != nil {
+		return user, err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.AccessToken)
+	req.Header.Set("Accept", "application/json")
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return user, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return user, fmt.Errorf("oidc: fetching userinfo: %s", res.Status)
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&user.RawData)
+	if err != nil {
+		return user, fmt.Errorf("oidc: decoding userinfo: %s", err)
+	}
+
+	err = userFromClaims(user.RawData, &user)
+	return user, err
+}
+
+// RefreshTokenAvailable says whether the provider gives out refresh tokens, which any of them might.
+func (p *OpenIDConnect) RefreshTokenAvailable() bool {
+	return true
+}
+
+// RefreshToken gets a new access token.
+func (p *OpenIDConnect) RefreshToken(refreshToken string) (*oauth2.Token, error) {
+	token := &oauth2.Token{RefreshToken: refreshToken}
+	return p.config.TokenSource(oauth2.NoContext, token).Token()
+}
+
+// OpenIDConnectSession is what gothic keeps between sending the user to the provider and them coming back.
+type OpenIDConnectSession struct {
+	AuthURL      string
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    time.Time
+}
+
+// Make sure the OpenIDConnectSession conforms to goth.Session.
+var _ goth.Session = &OpenIDConnectSession{}
+
+// GetAuthURL gives the URL set by BeginAuth().
+func (s *OpenIDConnectSession) GetAuthURL() (string, error) {
+	if s.AuthURL == "" {
+		return "", errors.New("oidc: an AuthURL has not been set")
+	}
+	return s.AuthURL, nil
+}
+
+// Authorize swaps the code the provider gave the user for an access token.
+func (s *OpenIDConnectSession) Authorize(provider goth.Provider, params goth.Params) (string, error) {
+	p := provider.(*OpenIDConnect)
+	token, err := p.config.Exchange(oauth2.NoContext, params.Get("code"))
+	if err != nil {
+		return "", err
+	}
+	if !token.Valid() {
+		return "", errors.New("oidc: invalid token received from provider")
+	}
+
+	s.AccessToken = token.AccessToken
+	s.RefreshToken = token.RefreshToken
+	s.ExpiresAt = token.Expiry
+	return token.AccessToken, nil
+}
+
+// Marshal the session into a string.
+func (s *OpenIDConnectSession) Marshal() string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// userFromClaims fills in the user from the standard claims, see
+// https://openid.net/specs/openid-connect-core-1_0.html#StandardClaims
+func userFromClaims(claims map[string]interface{}, user *goth.User) error {
+	claim := func(name string) string {
+		str, _ := claims[name].(string)
+		return str
+	}
+
+	user.UserID = claim("sub")
+	if user.UserID == "" {
+		return errors.New("oidc: userinfo has no subject")
+	}
+	user.Email = claim("email")
+	user.Name = claim("name")
+	user.FirstName = claim("given_name")
+	user.LastName = claim("family_name")
+	user.AvatarURL = claim("picture")
+	user.Location = claim("locale")
+
+	// not every provider has a username, so fall back to something which makes a reasonable one
+	user.NickName = claim("preferred_username")
+	if user.NickName == "" {
+		user.NickName = claim("nickname")
+	}
+	if user.NickName == "" && user.Email != "" {
+		user.NickName = strings.Split(user.Email, "@")[0]
+	}
+	if user.Name == "" {
+		user.Name = strings.TrimSpace(user.FirstName + " " + user.LastName)
+	}
+
+	return nil
+}
+
+func contains(list []string, str string) bool {
+	for _, s := range list {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
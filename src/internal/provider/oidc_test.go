@@ -0,0 +1,240 @@
+package provider
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/markbates/goth"
+)
+
+// mockIdP is a tiny OpenID Connect identity provider which hands out a single code and access token.
+type mockIdP struct {
+	*httptest.Server
+	issuer string // what the discovery document says, if not the server's own URL
+	claims map[string]interface{}
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	idp := &mockIdP{
+		claims: map[string]interface{}{
+			"sub":                "248289761001",
+			"name":               "Jane Doe",
+			"given_name":         "Jane",
+			"family_name":        "Doe",
+			"preferred_username": "j.doe",
+			"email":              "janedoe@example.com",
+			"picture":            "http://example.com/janedoe/me.jpg",
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(discoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		issuer := idp.issuer
+		if issuer == "" {
+			issuer = idp.URL
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"userinfo_endpoint":      idp.URL + "/userinfo",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		clientId, secret, _ := r.BasicAuth()
+		if clientId != "client-id" || secret != "client-secret" {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+		if r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("code") != "the-code" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"the-access-token","token_type":"Bearer","refresh_token":"the-refresh-token","expires_in":3600}`))
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer the-access-token" {
+			http.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(idp.claims)
+	})
+
+	idp.Server = httptest.NewServer(mux)
+	return idp
+}
+
+func TestOpenIDConnect(t *testing.T) {
+	idp := newMockIdP(t)
+	defer idp.Close()
+
+	p, err := NewOpenIDConnect("work", idp.URL+discoveryPath, "client-id", "client-secret", "http://localhost/auth/work/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name() != "work" {
+		t.Errorf("Name() = %q, want %q", p.Name(), "work")
+	}
+
+	// off to the provider
+	session, err := p.BeginAuth("the-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := session.GetAuthURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	if !strings.HasPrefix(authURL, idp.URL+"/authorize?") {
+		t.Errorf("auth URL = %q, want the authorization endpoint", authURL)
+	}
+	if query.Get("state") != "the-state" || query.Get("client_id") != "client-id" || query.Get("redirect_uri") != "http://localhost/auth/work/callback" {
+		t.Errorf("auth URL query = %v", query)
+	}
+	if query.Get("scope") != "openid profile email" {
+		t.Errorf("scope = %q, want %q", query.Get("scope"), "openid profile email")
+	}
+
+	// gothic keeps the session in a cookie while the user is away
+	session, err = p.UnmarshalSession(session.Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ... and back again
+	if _, err := session.Authorize(p, url.Values{"code": {"not-the-code"}}); err == nil {
+		t.Errorf("Authorize() with a bad code succeeded")
+	}
+	accessToken, err := session.Authorize(p, url.Values{"code": {"the-code"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accessToken != "the-access-token" {
+		t.Errorf("Authorize() = %q, want %q", accessToken, "the-access-token")
+	}
+
+	user, err := p.FetchUser(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := goth.User{
+		Provider:     "work",
+		UserID:       "248289761001",
+		Name:         "Jane Doe",
+		FirstName:    "Jane",
+		LastName:     "Doe",
+		NickName:     "j.doe",
+		Email:        "janedoe@example.com",
+		AvatarURL:    "http://example.com/janedoe/me.jpg",
+		AccessToken:  "the-access-token",
+		RefreshToken: "the-refresh-token",
+	}
+	user.RawData = nil
+	if user.ExpiresAt.IsZero() {
+		t.Errorf("user.ExpiresAt is not set")
+	}
+	user.ExpiresAt = want.ExpiresAt
+	if !reflect.DeepEqual(user, want) {
+		t.Errorf("FetchUser() = %#v, want %#v", user, want)
+	}
+}
+
+func TestOpenIDConnectNickName(t *testing.T) {
+	idp := newMockIdP(t)
+	defer idp.Close()
+
+	// without a username, the start of the email address will do
+	idp.claims = map[string]interface{}{"sub": "1", "email": "jane@example.com", "given_name": "Jane", "family_name": "Doe"}
+
+	p, err := NewOpenIDConnect("work", idp.URL, "client-id", "client-secret", "http://localhost/auth/work/callback", "email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(p.config.Scopes, " "); got != "openid email" {
+		t.Errorf("scopes = %q, want %q", got, "openid email")
+	}
+
+	user, err := p.FetchUser(&OpenIDConnectSession{AccessToken: "the-access-token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.NickName != "jane" || user.Name != "Jane Doe" {
+		t.Errorf("NickName, Name = %q, %q, want %q, %q", user.NickName, user.Name, "jane", "Jane Doe")
+	}
+
+	// a user has to be someone
+	idp.claims = map[string]interface{}{"email": "jane@example.com"}
+	if _, err := p.FetchUser(&OpenIDConnectSession{AccessToken: "the-access-token"}); err == nil {
+		t.Errorf("FetchUser() without a subject succeeded")
+	}
+
+	// and only with the right token
+	if _, err := p.FetchUser(&OpenIDConnectSession{AccessToken: "wrong"}); err == nil {
+		t.Errorf("FetchUser() with the wrong token succeeded")
+	}
+}
+
+func TestOpenIDConnectDiscovery(t *testing.T) {
+	idp := newMockIdP(t)
+	defer idp.Close()
+
+	// a document for some other issuer is refused
+	idp.issuer = "https://evil.example.com"
+	if _, err := NewOpenIDConnect("work", idp.URL, "client-id", "client-secret", ""); err == nil {
+		t.Errorf("NewOpenIDConnect() with the wrong issuer succeeded")
+	}
+
+	if _, err := NewOpenIDConnect("work", idp.URL+"/nope", "client-id", "client-secret", ""); err == nil {
+		t.Errorf("NewOpenIDConnect() without a discovery document succeeded")
+	}
+	if _, err := NewOpenIDConnect("work", "", "client-id", "client-secret", ""); err == nil {
+		t.Errorf("NewOpenIDConnect() without a discovery URL succeeded")
+	}
+}
+
+func TestUseOpenIDConnect(t *testing.T) {
+	idp := newMockIdP(t)
+	defer idp.Close()
+
+	env := map[string]string{
+		"DAFFY_PROVIDERS":            "my-sso",
+		"DAFFY_MY_SSO_TYPE":          "oidc",
+		"DAFFY_MY_SSO_TITLE":         "Work",
+		"DAFFY_MY_SSO_DISCOVERY_URL": idp.URL,
+		"DAFFY_MY_SSO_CLIENT_ID":     "client-id",
+		"DAFFY_MY_SSO_CLIENT_SECRET": "client-secret",
+		"DAFFY_GITHUB_CLIENT_ID":     "not listed",
+	}
+	configs, err := FromEnv(func(name string) string { return env[name] })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer goth.ClearProviders()
+	providers, err := Use(configs, "http://localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(providers) != 1 || providers[0] != (Provider{Name: "my-sso", Title: "Work"}) {
+		t.Errorf("Use() = %#v", providers)
+	}
+
+	p, err := goth.GetProvider("my-sso")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if callback := p.(*OpenIDConnect).CallbackURL; callback != "http://localhost/auth/my-sso/callback" {
+		t.Errorf("CallbackURL = %q", callback)
+	}
+}
@@ -0,0 +1,236 @@
+// Package provider sets up the social providers people can log in with, from the configuration in the environment.
+package provider
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/amazon"
+	"github.com/markbates/goth/providers/bitbucket"
+	"github.com/markbates/goth/providers/box"
+	"github.com/markbates/goth/providers/dailymotion"
+	"github.com/markbates/goth/providers/deezer"
+	"github.com/markbates/goth/providers/digitalocean"
+	"github.com/markbates/goth/providers/discord"
+	"github.com/markbates/goth/providers/dropbox"
+	"github.com/markbates/goth/providers/facebook"
+	"github.com/markbates/goth/providers/fitbit"
+	"github.com/markbates/goth/providers/github"
+	"github.com/markbates/goth/providers/gitlab"
+	"github.com/markbates/goth/providers/heroku"
+	"github.com/markbates/goth/providers/influxcloud"
+	"github.com/markbates/goth/providers/instagram"
+	"github.com/markbates/goth/providers/intercom"
+	"github.com/markbates/goth/providers/lastfm"
+	"github.com/markbates/goth/providers/linkedin"
+	"github.com/markbates/goth/providers/onedrive"
+	"github.com/markbates/goth/providers/paypal"
+	"github.com/markbates/goth/providers/salesforce"
+	"github.com/markbates/goth/providers/slack"
+	"github.com/markbates/goth/providers/soundcloud"
+	"github.com/markbates/goth/providers/spotify"
+	"github.com/markbates/goth/providers/steam"
+	"github.com/markbates/goth/providers/stripe"
+	"github.com/markbates/goth/providers/twitch"
+	"github.com/markbates/goth/providers/twitter"
+	"github.com/markbates/goth/providers/uber"
+	"github.com/markbates/goth/providers/wepay"
+	"github.com/markbates/goth/providers/yahoo"
+	"github.com/markbates/goth/providers/yammer"
+)
+
+// TypeOpenIDConnect is the Type of a generic OpenID Connect provider, see NewOpenIDConnect().
+const TypeOpenIDConnect = "oidc"
+
+// defaultNames are the providers looked for when `DAFFY_PROVIDERS` isn't set. Any without a client Id are skipped.
+var defaultNames = []string{"twitter", "github", "gitlab", "google"}
+
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// Config is everything needed to set up one provider.
+type Config struct {
+	Name         string   // e.g. "github" - used in the URLs and as the start of every social Id
+	Type         string   // e.g. "github" or "oidc" - which goth provider to use, which defaults to the Name
+	Title        string   // e.g. "GitHub" - shown in the log in links
+	ClientId     string   // the client Id (or consumer key) given to us by the provider
+	ClientSecret string   // ... and its secret
+	Scopes       []string // e.g. ["read:user"] - otherwise the provider's defaults
+	DiscoveryURL string   // e.g. "https://accounts.google.com" - for OpenID Connect only
+}
+
+// presets are the settings for some well known providers, so that usually only the client Id and secret are needed.
+var presets = map[string]Config{
+	"twitter": {Title: "Twitter"},
+	"github":  {Title: "GitHub"},
+	"gitlab":  {Title: "GitLab", Scopes: []string{"read_user"}},
+	"google":  {Title: "Google", Type: TypeOpenIDConnect, DiscoveryURL: "https://accounts.google.com"},
+}
+
+// Provider is one enabled provider, as shown in the templates.
+type Provider struct {
+	Name  string // e.g. "github"
+	Title string // e.g. "GitHub"
+}
+
+type newFn func(clientKey, secret, callbackURL string, scopes ...string) goth.Provider
+
+// gothProviders are all of the goth providers which can be used just by giving their name as the Type. Google+ is
+// deliberately missing since its API has been shut down, so use "google" (i.e. OpenID Connect) instead.
+var gothProviders = map[string]newFn{
+	"amazon":       func(k, s, c string, scopes ...string) goth.Provider { return amazon.New(k, s, c, scopes...) },
+	"bitbucket":    func(k, s, c string, scopes ...string) goth.Provider { return bitbucket.New(k, s, c, scopes...) },
+	"box":          func(k, s, c string, scopes ...string) goth.Provider { return box.New(k, s, c, scopes...) },
+	"dailymotion":  func(k, s, c string, scopes ...string) goth.Provider { return dailymotion.New(k, s, c, scopes...) },
+	"deezer":       func(k, s, c string, scopes ...string) goth.Provider { return deezer.New(k, s, c, scopes...) },
+	"digitalocean": func(k, s, c string, scopes ...string) goth.Provider { return digitalocean.New(k, s, c, scopes...) },
+	"discord":      func(k, s, c string, scopes ...string) goth.Provider { return discord.New(k, s, c, scopes...) },
+	"dropbox":      func(k, s, c string, scopes ...string) goth.Provider { return dropbox.New(k, s, c, scopes...) },
+	"facebook":     func(k, s, c string, scopes ...string) goth.Provider { return facebook.New(k, s, c, scopes...) },
+	"fitbit":       func(k, s, c string, scopes ...string) goth.Provider { return fitbit.New(k, s, c, scopes...) },
+	"github":       func(k, s, c string, scopes ...string) goth.Provider { return github.New(k, s, c, scopes...) },
+	"gitlab":       func(k, s, c string, scopes ...string) goth.Provider { return gitlab.New(k, s, c, scopes...) },
+	"heroku":       func(k, s, c string, scopes ...string) goth.Provider { return heroku.New(k, s, c, scopes...) },
+	"influxcloud":  func(k, s, c string, scopes ...string) goth.Provider { return influxcloud.New(k, s, c, scopes...) },
+	"instagram":    func(k, s, c string, scopes ...string) goth.Provider { return instagram.New(k, s, c, scopes...) },
+	"intercom":     func(k, s, c string, scopes ...string) goth.Provider { return intercom.New(k, s, c, scopes...) },
+	"lastfm":       func(k, s, c string, scopes ...string) goth.Provider { return lastfm.New(k, s, c) },
+	"linkedin":     func(k, s, c string, scopes ...string) goth.Provider { return linkedin.New(k, s, c, scopes...) },
+	"onedrive":     func(k, s, c string, scopes ...string) goth.Provider { return onedrive.New(k, s, c, scopes...) },
+	"paypal":       func(k, s, c string, scopes ...string) goth.Provider { return paypal.New(k, s, c, scopes...) },
+	"salesforce":   func(k, s, c string, scopes ...string) goth.Provider { return salesforce.New(k, s, c, scopes...) },
+	"slack":        func(k, s, c string, scopes ...string) goth.Provider { return slack.New(k, s, c, scopes...) },
+	"soundcloud":   func(k, s, c string, scopes ...string) goth.Provider { return soundcloud.New(k, s, c, scopes...) },
+	"spotify":      func(k, s, c string, scopes ...string) goth.Provider { return spotify.New(k, s, c, scopes...) },
+	"steam":        func(k, s, c string, scopes ...string) goth.Provider { return steam.New(k, c) },
+	"stripe":       func(k, s, c string, scopes ...string) goth.Provider { return stripe.New(k, s, c, scopes...) },
+	"twitch":       func(k, s, c string, scopes ...string) goth.Provider { return twitch.New(k, s, c, scopes...) },
+	"twitter":      func(k, s, c string, scopes ...string) goth.Provider { return twitter.NewAuthenticate(k, s, c) },
+	"uber":         func(k, s, c string, scopes ...string) goth.Provider { return uber.New(k, s, c, scopes...) },
+	"wepay":        func(k, s, c string, scopes ...string) goth.Provider { return wepay.New(k, s, c, scopes...) },
+	"yahoo":        func(k, s, c string, scopes ...string) goth.Provider { return yahoo.New(k, s, c, scopes...) },
+	"yammer":       func(k, s, c string, scopes ...string) goth.Provider { return yammer.New(k, s, c, scopes...) },
+}
+
+// FromEnv reads the config of every provider. `DAFFY_PROVIDERS` lists them by name (e.g. "twitter,github,work") and
+// each one is then configured with `DAFFY_<NAME>_CLIENT_ID` and `DAFFY_<NAME>_CLIENT_SECRET`, plus optionally
+// `_TYPE`, `_TITLE`, `_SCOPES` and (for OpenID Connect) `_DISCOVERY_URL`. A name with a dash uses an underscore in
+// the variables, so "my-sso" is `DAFFY_MY_SSO_CLIENT_ID`.
+//
+// Without `DAFFY_PROVIDERS` we use whichever of the well known providers have been given a client Id.
+func FromEnv(getenv func(string) string) ([]Config, error) {
+	names := defaultNames
+	explicit := getenv("DAFFY_PROVIDERS") != ""
+	if explicit {
+		names = strings.FieldsFunc(getenv("DAFFY_PROVIDERS"), isSeparator)
+	}
+
+	configs := make([]Config, 0, len(names))
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if !validName.MatchString(name) {
+			return nil, fmt.Errorf("provider %q: names can only contain a-z, 0-9 and dashes", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("provider %q: listed more than once", name)
+		}
+		seen[name] = true
+
+		prefix := "DAFFY_" + strings.ToUpper(strings.Replace(name, "-", "_", -1)) + "_"
+		config := presets[name]
+		config.Name = name
+		if typ := getenv(prefix + "TYPE"); typ != "" {
+			config.Type = strings.ToLower(typ)
+		}
+		if title := getenv(prefix + "TITLE"); title != "" {
+			config.Title = title
+		}
+		if scopes := getenv(prefix + "SCOPES"); scopes != "" {
+			config.Scopes = strings.FieldsFunc(scopes, isSeparator)
+		}
+		if discoveryURL := getenv(prefix + "DISCOVERY_URL"); discoveryURL != "" {
+			config.DiscoveryURL = discoveryURL
+		}
+		config.ClientId = getenv(prefix + "CLIENT_ID")
+		config.ClientSecret = getenv(prefix + "CLIENT_SECRET")
+
+		// Twitter calls them consumer keys, which is what these have always been called here
+		if config.ClientId == "" && name == "twitter" {
+			config.ClientId = getenv(prefix + "CONSUMER_KEY")
+			config.ClientSecret = getenv(prefix + "CONSUMER_SECRET")
+		}
+
+		if config.ClientId == "" {
+			if explicit {
+				return nil, fmt.Errorf("provider %q: %sCLIENT_ID must be set", name, prefix)
+			}
+			continue
+		}
+
+		configs = append(configs, config)
+	}
+
+	return configs, nil
+}
+
+// New creates the goth provider for this config, which will come back to callbackURL.
+func New(config Config, callbackURL string) (goth.Provider, error) {
+	typ := config.Type
+	if typ == "" {
+		typ = config.Name
+	}
+
+	if typ == TypeOpenIDConnect {
+		return NewOpenIDConnect(config.Name, config.DiscoveryURL, config.ClientId, config.ClientSecret, callbackURL, config.Scopes...)
+	}
+
+	fn, ok := gothProviders[typ]
+	if !ok {
+		return nil, fmt.Errorf("provider %q: unknown type %q", config.Name, typ)
+	}
+	// goth's own providers always use their own name, so there can only be one of each
+	if config.Name != typ {
+		return nil, fmt.Errorf("provider %q: only OpenID Connect providers can be given their own name", config.Name)
+	}
+	return fn(config.ClientId, config.ClientSecret, callbackURL, config.Scopes...), nil
+}
+
+// Use creates every provider and tells goth about them, giving back the list to show in the templates. Each provider
+// comes back to `<baseUrl>/auth/<name>/callback`.
+func Use(configs []Config, baseUrl string) ([]Provider, error) {
+	providers := make([]Provider, 0, len(configs))
+	for _, config := range configs {
+		p, err := New(config, baseUrl+"/auth/"+config.Name+"/callback")
+		if err != nil {
+			return nil, err
+		}
+		goth.UseProviders(p)
+
+		title := config.Title
+		if title == "" {
+			title = strings.Title(config.Name)
+		}
+		providers = append(providers, Provider{Name: config.Name, Title: title})
+	}
+	return providers, nil
+}
+
+// TwitterConsumer gives back the consumer key and secret of the registered twitter provider, so we can sign our own
+// calls to Twitter's API on behalf of the users who logged in with it.
+func TwitterConsumer() (string, string, error) {
+	p, err := goth.GetProvider("twitter")
+	if err != nil {
+		return "", "", err
+	}
+	tp, ok := p.(*twitter.Provider)
+	if !ok {
+		return "", "", fmt.Errorf("provider %q isn't Twitter", p.Name())
+	}
+	return tp.ClientKey, tp.Secret, nil
+}
+
+func isSeparator(r rune) bool {
+	return r == ',' || r == ' '
+}
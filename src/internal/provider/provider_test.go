@@ -0,0 +1,135 @@
+package provider
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/markbates/goth"
+)
+
+func TestFromEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want []Config
+		err  bool
+	}{
+		{
+			"nothing configured",
+			map[string]string{},
+			[]Config{},
+			false,
+		},
+		{
+			"defaults with credentials",
+			map[string]string{
+				"DAFFY_TWITTER_CONSUMER_KEY":    "tk",
+				"DAFFY_TWITTER_CONSUMER_SECRET": "ts",
+				"DAFFY_GOOGLE_CLIENT_ID":        "gk",
+				"DAFFY_GOOGLE_CLIENT_SECRET":    "gs",
+			},
+			[]Config{
+				{Name: "twitter", Title: "Twitter", ClientId: "tk", ClientSecret: "ts"},
+				{Name: "google", Type: "oidc", Title: "Google", ClientId: "gk", ClientSecret: "gs", DiscoveryURL: "https://accounts.google.com"},
+			},
+			false,
+		},
+		{
+			"listed with scopes",
+			map[string]string{
+				"DAFFY_PROVIDERS":            "GitLab, facebook",
+				"DAFFY_GITLAB_CLIENT_ID":     "lk",
+				"DAFFY_FACEBOOK_CLIENT_ID":   "fk",
+				"DAFFY_FACEBOOK_SCOPES":      "email,public_profile",
+				"DAFFY_FACEBOOK_TITLE":       "Facebook",
+				"DAFFY_GITHUB_CLIENT_ID":     "not listed",
+				"DAFFY_GITHUB_CLIENT_SECRET": "not listed",
+			},
+			[]Config{
+				{Name: "gitlab", Title: "GitLab", ClientId: "lk", Scopes: []string{"read_user"}},
+				{Name: "facebook", Title: "Facebook", ClientId: "fk", Scopes: []string{"email", "public_profile"}},
+			},
+			false,
+		},
+		{
+			"listed without credentials",
+			map[string]string{"DAFFY_PROVIDERS": "github"},
+			nil,
+			true,
+		},
+		{
+			"listed twice",
+			map[string]string{"DAFFY_PROVIDERS": "github,github", "DAFFY_GITHUB_CLIENT_ID": "hk"},
+			nil,
+			true,
+		},
+		{
+			"invalid name",
+			map[string]string{"DAFFY_PROVIDERS": "git_hub"},
+			nil,
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			configs, err := FromEnv(func(name string) string { return test.env[name] })
+			if test.err {
+				if err == nil {
+					t.Errorf("FromEnv() = %#v, want an error", configs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(configs, test.want) {
+				t.Errorf("FromEnv() = %#v, want %#v", configs, test.want)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	p, err := New(Config{Name: "github", ClientId: "hk", ClientSecret: "hs"}, "http://localhost/auth/github/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name() != "github" {
+		t.Errorf("Name() = %q, want %q", p.Name(), "github")
+	}
+
+	if _, err := New(Config{Name: "myspace", ClientId: "mk"}, ""); err == nil {
+		t.Errorf("New() with an unknown type succeeded")
+	}
+	if _, err := New(Config{Name: "work", Type: "github", ClientId: "hk"}, ""); err == nil {
+		t.Errorf("New() with a goth provider under another name succeeded")
+	}
+}
+
+func TestTwitterConsumer(t *testing.T) {
+	goth.ClearProviders()
+	defer goth.ClearProviders()
+
+	if _, _, err := TwitterConsumer(); err == nil {
+		t.Errorf("TwitterConsumer() without a twitter provider succeeded")
+	}
+
+	// either name for the key works, since it comes from the provider itself
+	configs, err := FromEnv(func(name string) string {
+		return map[string]string{
+			"DAFFY_PROVIDERS":             "twitter",
+			"DAFFY_TWITTER_CLIENT_ID":     "tk",
+			"DAFFY_TWITTER_CLIENT_SECRET": "ts",
+		}[name]
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Use(configs, "http://localhost"); err != nil {
+		t.Fatal(err)
+	}
+	if key, secret, err := TwitterConsumer(); key != "tk" || secret != "ts" || err != nil {
+		t.Errorf("TwitterConsumer() = %q, %q, %v", key, secret, err)
+	}
+}
@@ -0,0 +1,133 @@
+package sess
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/sessions"
+
+	"internal/store"
+)
+
+var ErrAuthStateMismatch = errors.New("This log in wasn't started by this browser, or has expired")
+
+// authStateKey is where the session keeps the state of the log in attempt which is in progress.
+const authStateKey = "auth-state"
+
+// authRequestTokenKey is where the session keeps the OAuth 1.0a request token given out for that attempt, if any.
+const authRequestTokenKey = "auth-request-token"
+
+type contextKey int
+
+const authStateContextKey contextKey = 1
+
+// BeginAuth records a new log in attempt with the provider and gives back its signed state, which is also put in the
+// session so the callback can tell this browser started it. Like LogIn, the session still needs to be saved.
+func BeginAuth(r *http.Request, sessionStore sessions.Store, sessionName string, api store.Api, key []byte, provider string) (string, error) {
+	state, err := api.InsAuthState(provider)
+	if err != nil {
+		return "", err
+	}
+
+	signed := signAuthState(key, state.Id)
+
+	session, _ := sessionStore.Get(r, sessionName)
+	session.Values[authStateKey] = signed
+	delete(session.Values, authRequestTokenKey)
+	return signed, nil
+}
+
+// BindRequestToken remembers the request token in the provider's authURL (if it has one) alongside the state of this
+// attempt. OAuth 1.0a providers (i.e. Twitter) don't send our state back, but they do send back this token, which is
+// how CompleteAuth() knows the attempt is the one this browser started. The session still needs to be saved.
+func BindRequestToken(r *http.Request, sessionStore sessions.Store, sessionName string, authURL string) error {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return err
+	}
+
+	session, _ := sessionStore.Get(r, sessionName)
+	if token := u.Query().Get("oauth_token"); token != "" {
+		session.Values[authRequestTokenKey] = token
+	} else {
+		delete(session.Values, authRequestTokenKey)
+	}
+	return nil
+}
+
+// CompleteAuth checks the provider has sent us back to finish the attempt this browser started, and uses it up so it
+// can't be replayed. It returns ErrAuthStateMismatch if anything doesn't match up. The session still needs to be saved.
+func CompleteAuth(r *http.Request, sessionStore sessions.Store, sessionName string, api store.Api, key []byte, provider string) error {
+	session, _ := sessionStore.Get(r, sessionName)
+	expected, _ := session.Values[authStateKey].(string)
+	requestToken, _ := session.Values[authRequestTokenKey].(string)
+	delete(session.Values, authStateKey)
+	delete(session.Values, authRequestTokenKey)
+	if expected == "" {
+		return ErrAuthStateMismatch
+	}
+
+	query := r.URL.Query()
+	got := query.Get("state")
+	if got == "" && requestToken != "" {
+		// OAuth 1.0a providers (i.e. Twitter) send back the request token instead, see BindRequestToken()
+		if !hmac.Equal([]byte(query.Get("oauth_token")), []byte(requestToken)) {
+			return ErrAuthStateMismatch
+		}
+		got = expected
+	}
+	if !hmac.Equal([]byte(got), []byte(expected)) {
+		return ErrAuthStateMismatch
+	}
+
+	stateId, ok := verifyAuthState(key, got)
+	if !ok {
+		return ErrAuthStateMismatch
+	}
+	state, err := api.TakeAuthState(stateId)
+	if err != nil {
+		return err
+	}
+	if state == nil || state.Provider != provider {
+		return ErrAuthStateMismatch
+	}
+
+	return nil
+}
+
+// WithAuthState puts the signed state in the request's context, which is where our `gothic.SetState` picks it up from.
+func WithAuthState(r *http.Request, state string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), authStateContextKey, state))
+}
+
+// GetAuthState gives the signed state put there by WithAuthState(), or "" if there isn't one.
+func GetAuthState(r *http.Request) string {
+	state, _ := r.Context().Value(authStateContextKey).(string)
+	return state
+}
+
+// signAuthState gives "<id>.<signature>", so a state we didn't make is thrown out before we go anywhere near the store.
+func signAuthState(key []byte, stateId string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(stateId))
+	return stateId + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyAuthState checks the signature and gives back the Id of the attempt.
+func verifyAuthState(key []byte, signed string) (string, bool) {
+	i := strings.LastIndex(signed, ".")
+	if i < 0 {
+		return "", false
+	}
+	stateId := signed[:i]
+	if !hmac.Equal([]byte(signAuthState(key, stateId)), []byte(signed)) {
+		return "", false
+	}
+	return stateId, true
+}
@@ -0,0 +1,211 @@
+package sess
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/chilts/rod"
+	"github.com/gorilla/sessions"
+
+	"internal/store"
+	"internal/types"
+)
+
+const testSessionName = "session"
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+type authTest struct {
+	t            *testing.T
+	sessionStore sessions.Store
+	api          *store.BoltStore
+}
+
+func newAuthTest(t *testing.T) (*authTest, func()) {
+	dir, err := ioutil.TempDir("", "daffy-sess-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := store.NewBoltStore(path.Join(dir, "daffy.db"))
+	if err := api.Open(); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	at := &authTest{t, sessions.NewCookieStore(testKey), api}
+	return at, func() {
+		api.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// begin starts a log in attempt with the provider, which sends the user to authURL. It gives back the signed state and
+// the session cookie the browser would then have.
+func (at *authTest) begin(provider, authURL string) (string, *http.Cookie) {
+	r := httptest.NewRequest(http.MethodGet, "/auth/"+provider, nil)
+	state, err := BeginAuth(r, at.sessionStore, testSessionName, at.api, testKey, provider)
+	if err != nil {
+		at.t.Fatal(err)
+	}
+	if err := BindRequestToken(r, at.sessionStore, testSessionName, authURL); err != nil {
+		at.t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	if err := sessions.Save(r, rec); err != nil {
+		at.t.Fatal(err)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 {
+		at.t.Fatalf("BeginAuth() set cookies %v", cookies)
+	}
+	return state, cookies[0]
+}
+
+// complete comes back from the provider with this query, in the browser with this cookie (if any).
+func (at *authTest) complete(provider string, cookie *http.Cookie, query string) error {
+	r := httptest.NewRequest(http.MethodGet, "/auth/"+provider+"/callback?"+query, nil)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	return CompleteAuth(r, at.sessionStore, testSessionName, at.api, testKey, provider)
+}
+
+func TestCompleteAuth(t *testing.T) {
+	at, done := newAuthTest(t)
+	defer done()
+
+	state, cookie := at.begin("github", "https://github.com/login/oauth/authorize?state=whatever")
+	if err := at.complete("github", cookie, "code=abc&state="+state); err != nil {
+		t.Fatalf("CompleteAuth() = %v", err)
+	}
+
+	// it can only be used once, even by the same browser
+	if err := at.complete("github", cookie, "code=abc&state="+state); err != ErrAuthStateMismatch {
+		t.Errorf("replayed CompleteAuth() = %v, want %v", err, ErrAuthStateMismatch)
+	}
+}
+
+func TestCompleteAuthMismatch(t *testing.T) {
+	at, done := newAuthTest(t)
+	defer done()
+
+	tests := []struct {
+		name     string
+		provider string
+		cookie   bool
+		query    func(state string) string
+	}{
+		{"no state", "github", true, func(state string) string { return "code=abc" }},
+		{"no session", "github", false, func(state string) string { return "code=abc&state=" + state }},
+		{"another provider", "gitlab", true, func(state string) string { return "code=abc&state=" + state }},
+		{"tampered signature", "github", true, func(state string) string { return "code=abc&state=" + state + "x" }},
+		{"tampered id", "github", true, func(state string) string { return "code=abc&state=x" + state }},
+		{"OAuth1 token without an OAuth1 attempt", "github", true, func(state string) string { return "oauth_token=abc&oauth_verifier=def" }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state, cookie := at.begin("github", "https://github.com/login/oauth/authorize")
+			if !test.cookie {
+				cookie = nil
+			}
+			if err := at.complete(test.provider, cookie, test.query(state)); err != ErrAuthStateMismatch {
+				t.Errorf("CompleteAuth() = %v, want %v", err, ErrAuthStateMismatch)
+			}
+		})
+	}
+}
+
+func TestCompleteAuthTamperedSession(t *testing.T) {
+	at, done := newAuthTest(t)
+	defer done()
+
+	// a state signed with some other key matches itself in the session, but not our signature
+	state, cookie := at.begin("github", "https://github.com/login/oauth/authorize")
+	stateId := state[:strings.LastIndex(state, ".")]
+	forged := signAuthState([]byte("not-our-key"), stateId)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookie)
+	session, _ := at.sessionStore.Get(r, testSessionName)
+	session.Values[authStateKey] = forged
+	rec := httptest.NewRecorder()
+	session.Save(r, rec)
+
+	if err := at.complete("github", rec.Result().Cookies()[0], "code=abc&state="+forged); err != ErrAuthStateMismatch {
+		t.Errorf("CompleteAuth() = %v, want %v", err, ErrAuthStateMismatch)
+	}
+}
+
+func TestCompleteAuthExpired(t *testing.T) {
+	at, done := newAuthTest(t)
+	defer done()
+
+	state, cookie := at.begin("github", "https://github.com/login/oauth/authorize")
+
+	// the user took too long at the provider
+	stateId, ok := verifyAuthState(testKey, state)
+	if !ok {
+		t.Fatalf("verifyAuthState(%q) failed", state)
+	}
+	err := at.api.GetDB().Update(func(tx *bolt.Tx) error {
+		var record types.AuthState
+		if err := rod.GetJson(tx, "auth-state", stateId, &record); err != nil {
+			return err
+		}
+		record.Expires = time.Now().Add(-time.Second)
+		return rod.PutJson(tx, "auth-state", stateId, record)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := at.complete("github", cookie, "code=abc&state="+state); err != ErrAuthStateMismatch {
+		t.Errorf("CompleteAuth() = %v, want %v", err, ErrAuthStateMismatch)
+	}
+}
+
+func TestCompleteAuthOAuth1(t *testing.T) {
+	at, done := newAuthTest(t)
+	defer done()
+
+	// Twitter never sends the state back, only the request token it gave us
+	authURL := "https://api.twitter.com/oauth/authenticate?oauth_token=request-token"
+	_, cookie := at.begin("twitter", authURL)
+	if err := at.complete("twitter", cookie, "oauth_token=request-token&oauth_verifier=abc"); err != nil {
+		t.Fatalf("CompleteAuth() = %v", err)
+	}
+
+	// and someone else's request token isn't ours
+	_, cookie = at.begin("twitter", authURL)
+	if err := at.complete("twitter", cookie, "oauth_token=someone-elses&oauth_verifier=abc"); err != ErrAuthStateMismatch {
+		t.Errorf("CompleteAuth() with another request token = %v, want %v", err, ErrAuthStateMismatch)
+	}
+
+	// nor is no token at all
+	_, cookie = at.begin("twitter", authURL)
+	if err := at.complete("twitter", cookie, "oauth_verifier=abc"); err != ErrAuthStateMismatch {
+		t.Errorf("CompleteAuth() without a request token = %v, want %v", err, ErrAuthStateMismatch)
+	}
+}
+
+func TestSignAuthState(t *testing.T) {
+	signed := signAuthState(testKey, "some-id")
+	if stateId, ok := verifyAuthState(testKey, signed); !ok || stateId != "some-id" {
+		t.Errorf("verifyAuthState(%q) = %q, %v", signed, stateId, ok)
+	}
+
+	for _, bad := range []string{"", "some-id", "some-id.", signed + "x", "other-id" + signed[len("some-id"):], signAuthState([]byte("other-key"), "some-id")} {
+		if _, ok := verifyAuthState(testKey, bad); ok {
+			t.Errorf("verifyAuthState(%q) succeeded", bad)
+		}
+	}
+}
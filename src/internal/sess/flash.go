@@ -0,0 +1,35 @@
+package sess
+
+import (
+	"net/http"
+
+	"github.com/gorilla/sessions"
+
+	"internal/types"
+)
+
+// AddFlash queues a message to be shown on the next page the user sees. Like anything else put in the session, it only
+// sticks once the session has been saved, which is usually done just before the redirect.
+func AddFlash(r *http.Request, sessionStore sessions.Store, sessionName, kind, message string) {
+	session, _ := sessionStore.Get(r, sessionName)
+	session.AddFlash(types.Flash{Kind: kind, Message: message})
+}
+
+// GetFlashes takes every queued message out of the session (saving it) so each one is only ever shown once.
+func GetFlashes(w http.ResponseWriter, r *http.Request, sessionStore sessions.Store, sessionName string) []types.Flash {
+	session, _ := sessionStore.Get(r, sessionName)
+
+	values := session.Flashes()
+	if len(values) == 0 {
+		return nil
+	}
+	session.Save(r, w)
+
+	flashes := make([]types.Flash, 0, len(values))
+	for _, v := range values {
+		if flash, ok := v.(types.Flash); ok {
+			flashes = append(flashes, flash)
+		}
+	}
+	return flashes
+}
@@ -0,0 +1,70 @@
+package sess
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/sessions"
+)
+
+// nextKey is where the session keeps the page to go back to once the user has logged in.
+const nextKey = "next"
+
+// nextAllowed are the only places we'll send someone back to after logging in. Anything else (and especially anything
+// on another site) is ignored, so a link to `/auth/twitter?next=...` can't be used to bounce people elsewhere.
+var nextAllowed = []string{
+	"/my/",
+	"/settings/",
+	"/u/",
+}
+
+// SetNext remembers where to go after logging in, as long as it is one of our own pages. Like AddFlash, the session
+// still needs to be saved.
+func SetNext(r *http.Request, sessionStore sessions.Store, sessionName, next string) {
+	next = SafeNext(next)
+	if next == "" {
+		return
+	}
+
+	session, _ := sessionStore.Get(r, sessionName)
+	session.Values[nextKey] = next
+}
+
+// TakeNext gives the page to go back to (or def if there isn't one) and takes it out of the session. The session still
+// needs to be saved.
+func TakeNext(r *http.Request, sessionStore sessions.Store, sessionName, def string) string {
+	session, _ := sessionStore.Get(r, sessionName)
+	next, _ := session.Values[nextKey].(string)
+	delete(session.Values, nextKey)
+
+	// it was checked on the way in, but check again in case it was set by an older version
+	next = SafeNext(next)
+	if next == "" {
+		return def
+	}
+	return next
+}
+
+// SafeNext gives back next if it is a path on this site which is in the allow-list, otherwise "".
+func SafeNext(next string) string {
+	// no "//evil.com" or "/\evil.com", which browsers treat as another host
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return ""
+	}
+
+	u, err := url.Parse(next)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil {
+		return ""
+	}
+	if strings.Contains(u.Path, "..") || strings.ContainsAny(next, "\\\r\n") {
+		return ""
+	}
+
+	for _, prefix := range nextAllowed {
+		if strings.HasPrefix(u.Path, prefix) {
+			return next
+		}
+	}
+	return ""
+}
@@ -0,0 +1,55 @@
+package sess
+
+import "testing"
+
+func TestSafeNext(t *testing.T) {
+	tests := []struct {
+		next string
+		want string
+	}{
+		// each of the allowed places
+		{"/my/", "/my/"},
+		{"/my/tweet", "/my/tweet"},
+		{"/settings/", "/settings/"},
+		{"/settings/tokens?page=2", "/settings/tokens?page=2"},
+		{"/u/chilts", "/u/chilts"},
+
+		// nothing at all
+		{"", ""},
+
+		// our own pages which aren't allowed
+		{"/", ""},
+		{"/my", ""},
+		{"/logout", ""},
+		{"/auth/twitter", ""},
+		{"/api/v1/me", ""},
+
+		// other sites
+		{"//evil.com", ""},
+		{"//evil.com/my/", ""},
+		{"/\\evil.com", ""},
+		{"/%2F%2Fevil.com", ""},
+		{"https://evil.com", ""},
+		{"https://evil.com/my/", ""},
+		{"http:/my/", ""},
+		{"evil.com/my/", ""},
+		{"my/", ""},
+
+		// scripts
+		{"javascript:", ""},
+		{"javascript:alert(1)//my/", ""},
+
+		// escaping the allowed places
+		{"/my/../x", ""},
+		{"/my/%2e%2e/x", ""},
+		{"/settings/..", ""},
+		{"/my/\\evil.com", ""},
+		{"/my/\r\nLocation: https://evil.com", ""},
+	}
+
+	for _, test := range tests {
+		if got := SafeNext(test.next); got != test.want {
+			t.Errorf("SafeNext(%q) = %q, want %q", test.next, got, test.want)
+		}
+	}
+}
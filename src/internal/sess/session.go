@@ -1,18 +1,85 @@
 package sess
 
 import (
+	"net"
 	"net/http"
+	"strings"
 
 	"github.com/gorilla/sessions"
 
+	"internal/store"
 	"internal/types"
 )
 
-func GetUserFromSession(r *http.Request, sessionStore sessions.Store, sessionName string) *types.User {
+// GetUserId gives the Id of the logged in user, or "" if no-one is logged in. The session only ever holds the Id, so
+// use middleware.GetUser() to get the user themselves.
+func GetUserId(r *http.Request, sessionStore sessions.Store, sessionName string) string {
 	session, _ := sessionStore.Get(r, sessionName)
-	user, ok := session.Values["user"].(*types.User)
-	if !ok {
+	userId, _ := session.Values["uid"].(string)
+	return userId
+}
+
+// GetSessionId gives the Id of the types.Session this browser is logged in with, or "" if there isn't one.
+func GetSessionId(r *http.Request, sessionStore sessions.Store, sessionName string) string {
+	session, _ := sessionStore.Get(r, sessionName)
+	sessionId, _ := session.Values["sid"].(string)
+	return sessionId
+}
+
+// LogIn records a new types.Session for this browser and puts it in the session along with the user's Id. Anything
+// else which was in the session before they logged in (including the CSRF token) is thrown away, so a session someone
+// else managed to plant in this browser doesn't carry over. The only exception is where to go next, see SetNext(). Like
+// AddFlash, the session still needs to be saved.
+func LogIn(r *http.Request, sessionStore sessions.Store, sessionName string, api store.Api, userId string) error {
+	s, err := api.InsSession(userId, types.Session{
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+	})
+	if err != nil {
+		return err
+	}
+
+	session, _ := sessionStore.Get(r, sessionName)
+	for k := range session.Values {
+		if k != nextKey {
+			delete(session.Values, k)
+		}
+	}
+	session.Values["uid"] = userId
+	session.Values["sid"] = s.Id
+	return nil
+}
+
+// LogOut deletes this browser's types.Session from the store (if it is still there) and scrubs the user from the
+// session. Again, the session still needs to be saved.
+func LogOut(r *http.Request, sessionStore sessions.Store, sessionName string, api store.Api) error {
+	session, _ := sessionStore.Get(r, sessionName)
+
+	userId := GetUserId(r, sessionStore, sessionName)
+	sessionId := GetSessionId(r, sessionStore, sessionName)
+
+	delete(session.Values, "uid")
+	delete(session.Values, "sid")
+
+	if userId == "" || sessionId == "" {
+		return nil
+	}
+	err := api.DelSession(types.User{Id: userId}, sessionId)
+	if err == store.ErrSessionUnknown {
 		return nil
 	}
-	return user
+	return err
+}
+
+// clientIP uses the address given to us by the proxy in front of us (if any), otherwise the address of the connection.
+// It is only ever shown to the user, so it doesn't matter that the header could be made up.
+func clientIP(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }
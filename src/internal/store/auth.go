@@ -0,0 +1,23 @@
+package store
+
+import (
+	"time"
+
+	uuid "github.com/hashicorp/go-uuid"
+
+	"internal/types"
+)
+
+// authStateTTL is how long the user has to get to the provider and back again.
+const authStateTTL = 10 * time.Minute
+
+// newAuthState fills in the rest of the AuthState.
+func newAuthState(provider string, now time.Time) types.AuthState {
+	id, _ := uuid.GenerateUUID()
+	return types.AuthState{
+		Id:       id,
+		Provider: provider,
+		Expires:  now.Add(authStateTTL),
+		Inserted: now,
+	}
+}
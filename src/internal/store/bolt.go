@@ -3,11 +3,10 @@ package store
 import (
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"sort"
 	"time"
 
-	"github.com/Machiel/slugify"
-	valid "github.com/asaskevich/govalidator"
 	"github.com/boltdb/bolt"
 	"github.com/chilts/rod"
 	uuid "github.com/hashicorp/go-uuid"
@@ -21,17 +20,38 @@ var (
 
 	ErrUsernameAlreadyExists = errors.New("Username already exists")
 	ErrUsernameUnknown       = errors.New("Unknown username")
+
+	ErrUserUnknown = errors.New("Unknown user")
+
+	ErrSocialUnknown = errors.New("Unknown social account")
+	ErrLastSocial    = errors.New("You can't remove the only account you log in with")
+
+	ErrMergeSameUser = errors.New("Can't merge a user with themselves")
+	ErrMergeInvalid  = errors.New("Merged details must come from one of the two users")
+
+	ErrTokenUnknown = errors.New("Unknown token")
+
+	ErrSessionUnknown = errors.New("Unknown session")
 )
 
 var userBucket = "user"
 var socialBucket = "social"
+var eventBucket = "event"
+var postBucket = "post"
+var tokenBucket = "token"
+var sessionBucket = "session"
+var authStateBucket = "auth-state"
 var indexUserNameUniqueIndex = "i-u-n-u"
 
 type BoltStore struct {
 	filename string
 	db       *bolt.DB
+	keyring  *Keyring
 }
 
+// Make sure the BoltStore conforms to the Api interface.
+var _ Api = &BoltStore{}
+
 func now() time.Time {
 	return time.Now().UTC()
 }
@@ -42,7 +62,29 @@ func NewBoltStore(filename string) *BoltStore {
 	}
 }
 
+// SetKeyring sets the keys used to encrypt the OAuth tokens in the social bucket. Without one, tokens are stored in
+// plaintext.
+func (b *BoltStore) SetKeyring(keyring *Keyring) {
+	b.keyring = keyring
+}
+
+// Open opens the datastore and runs any pending migrations.
 func (b *BoltStore) Open() error {
+	err := b.OpenNoMigrate()
+	if err != nil {
+		return err
+	}
+
+	_, err = b.Migrate(false)
+	if err != nil {
+		b.db.Close()
+		return err
+	}
+	return nil
+}
+
+// OpenNoMigrate opens the datastore without running any migrations, e.g. to inspect or dry-run them.
+func (b *BoltStore) OpenNoMigrate() error {
 	// open the db
 	db, err := bolt.Open(b.filename, 0600, &bolt.Options{Timeout: 1 * time.Second})
 	b.db = db
@@ -54,57 +96,64 @@ func (b *BoltStore) Close() error {
 }
 
 func (b *BoltStore) LogInGoth(userId, provider string, authUser goth.User) (*types.User, error) {
-	return b.LogIn(userId, provider, authUser.UserID, authUser.NickName, authUser.Name, authUser.Email, authUser.AccessToken, authUser.AccessTokenSecret)
+	return b.LogIn(userId, socialLogInFromGoth(provider, authUser))
 }
 
-func (b *BoltStore) LogIn(userId, provider, id, nickName, title, email, accessToken, accessTokenSecret string) (*types.User, error) {
+func (b *BoltStore) LogIn(userId string, logIn types.SocialLogIn) (*types.User, error) {
 	var user types.User
 	now := time.Now().UTC()
 
 	isLoggedIn := userId != ""
 
 	// 1. see if this social id exists
-	// 2. if it does, read the user and return it
+	// 2. if it does, refresh it, read the user and return it
 	// 3. if it doesn't, add the Social and User types
 
-	fmt.Printf("boltStore.LogIn(): entry\n")
-	fmt.Printf("* userId=%#v\n", userId)
-	fmt.Printf("* provider=%#v\n", provider)
-	fmt.Printf("* id=%#v\n", id)
-	fmt.Printf("* nickName=%#v\n", nickName)
-	fmt.Printf("* title=%#v\n", title)
-	fmt.Printf("* email=%#v\n", email)
-
 	err := b.db.Update(func(tx *bolt.Tx) error {
 		// create a socialId that we use internally (to look the user up)
-		socialId := provider + ":" + id
+		socialId := logIn.SocialId()
 
 		// fetch this Social entity
 		var social types.Social
-		errGetSocial := rod.GetJson(tx, socialBucket, socialId, &social)
+		errGetSocial := b.getSocial(tx, socialId, &social)
 		if errGetSocial != nil {
 			return errGetSocial
 		}
 
 		// check to see if the socialId exists
 		if social.Id != "" {
-			fmt.Printf("Got social = %#v\n", social)
-
 			// Now that we have the user (from an existing Social), check to see if this matches the current user if
 			// one is currently logged in.
 			if isLoggedIn {
 				if social.UserId != userId {
-					fmt.Printf("Social account already exists and is owned by another user already")
 					return ErrSocialAccountAlreadyExists
 				}
 			}
 
+			// the provider may have rotated tokens or the user may have changed their details, so keep up to date
+			refreshSocial(&social, logIn, now)
+			errPutSocial := b.putSocial(tx, social)
+			if errPutSocial != nil {
+				return errPutSocial
+			}
+
 			// get this user - should ALWAYS work if the above Social exists
 			errGetUser := rod.GetJson(tx, userBucket, social.UserId, &user)
-			fmt.Printf("Got user = %#v\n", user)
 			if errGetUser != nil {
 				return errGetUser
 			}
+
+			// logging back in cancels any pending deletion
+			if !user.DeleteAfter.IsZero() {
+				user.DeleteAfter = time.Time{}
+				user.Updated = now
+				errPutUser := rod.PutJson(tx, userBucket, user.Id, user)
+				if errPutUser != nil {
+					return errPutUser
+				}
+				return putEvent(tx, newEvent(user.Id, types.EventUserDeleteCancelled, socialId, now))
+			}
+
 			return nil
 		}
 
@@ -115,21 +164,8 @@ func (b *BoltStore) LogIn(userId, provider, id, nickName, title, email, accessTo
 		}
 
 		// create the Social
-		social = types.Social{
-			Id:                socialId,
-			UserId:            userId,
-			Provider:          provider,
-			NickName:          nickName,
-			Title:             title,
-			Email:             email,
-			AccessToken:       accessToken,
-			AccessTokenSecret: accessTokenSecret,
-			// RefreshToken: "", //  always empty
-			Inserted: now,
-			Updated:  now,
-		}
-		fmt.Printf("Adding a new Social = %#v\n", social)
-		errPutSocial := rod.PutJson(tx, socialBucket, socialId, social)
+		social = newSocial(userId, logIn, now)
+		errPutSocial := b.putSocial(tx, social)
 		if errPutSocial != nil {
 			return errPutSocial
 		}
@@ -147,24 +183,29 @@ func (b *BoltStore) LogIn(userId, provider, id, nickName, title, email, accessTo
 			userName = user.Name
 		} else {
 			// create a unique userName for this user - they can change it if they like
-			userName = slugify.Slugify(nickName + "-" + id)
+			var errUserName error
+			userName, errUserName = newUserName(logIn.NickName, logIn.Id, func(name string) (bool, error) {
+				id, err := rod.GetString(tx, indexUserNameUniqueIndex, name)
+				return id != "", err
+			})
+			if errUserName != nil {
+				return errUserName
+			}
 
 			// create the User
 			user = types.User{
 				Id:    userId,
 				Name:  userName,
-				Title: title,
-				Email: email,
+				Title: logIn.Title,
+				Email: logIn.Email,
 				SocialIds: []string{
 					socialId,
 				},
 				Inserted: now,
 				Updated:  now,
 			}
-			fmt.Printf("Adding a new User = %#v\n", user)
 		}
 
-		fmt.Printf("* created userId=%#v\n", userId)
 		errPutUser := rod.PutJson(tx, userBucket, userId, user)
 		if errPutUser != nil {
 			return errPutUser
@@ -181,8 +222,6 @@ func (b *BoltStore) LogIn(userId, provider, id, nickName, title, email, accessTo
 			}
 		}
 
-		fmt.Printf("all done\n")
-
 		return nil
 	})
 
@@ -196,7 +235,7 @@ func (b *BoltStore) SelSocials(socialIds []string) ([]types.Social, error) {
 		for _, socialId := range socialIds {
 			social := types.Social{}
 
-			errGetJson := rod.GetJson(tx, socialBucket, socialId, &social)
+			errGetJson := b.getSocial(tx, socialId, &social)
 			if errGetJson != nil {
 				return errGetJson
 			}
@@ -229,6 +268,10 @@ func (b *BoltStore) GetUserPublic(username string) (*types.User, error) {
 		if errGetJson != nil {
 			return errGetJson
 		}
+		// users waiting to be deleted are no longer public
+		if !newUser.DeleteAfter.IsZero() {
+			return nil
+		}
 		user = &newUser
 		return nil
 	})
@@ -236,26 +279,86 @@ func (b *BoltStore) GetUserPublic(username string) (*types.User, error) {
 	return user, err
 }
 
-func (b *BoltStore) UpdateUser(currentUser types.User, updateUser types.UpdateUser) (types.User, error) {
-	var user types.User
-	now := now()
+func (b *BoltStore) GetUser(userId string) (*types.User, error) {
+	var user *types.User
 
-	// first thing to do is validate the incoming info
-	isValid, errs := valid.ValidateStruct(updateUser)
-	if errs != nil {
-		return user, errs
-	}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var newUser types.User
+		errGetJson := rod.GetJson(tx, userBucket, userId, &newUser)
+		if errGetJson != nil {
+			return errGetJson
+		}
+		if newUser.Id == "" {
+			return nil
+		}
+		user = &newUser
+		return nil
+	})
 
-	log.Printf("isValid = %#v\n", isValid)
-	log.Printf("errs    = %#v\n", errs)
+	return user, err
+}
 
-	if !isValid {
-		// ToDo: ... !!!
+func (b *BoltStore) GetUserBySocialId(socialId string) (*types.User, error) {
+	var user *types.User
 
-		// re-render the page with the errors
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var social types.Social
+		errGetSocial := rod.GetJson(tx, socialBucket, socialId, &social)
+		if errGetSocial != nil {
+			return errGetSocial
+		}
+		if social.Id == "" {
+			return nil
+		}
 
-		// ToDo: ... !!!
-	}
+		var newUser types.User
+		errGetJson := rod.GetJson(tx, userBucket, social.UserId, &newUser)
+		if errGetJson != nil {
+			return errGetJson
+		}
+		if newUser.Id == "" {
+			return nil
+		}
+		user = &newUser
+		return nil
+	})
+
+	return user, err
+}
+
+func (b *BoltStore) SelPosts(userId string) ([]types.Post, error) {
+	posts := make([]types.Post, 0)
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return rod.SelAll(tx, postBucket+"."+userId, func() interface{} {
+			return &types.Post{}
+		}, func(v interface{}) {
+			posts = append(posts, *v.(*types.Post))
+		})
+	})
+
+	return posts, err
+}
+
+func (b *BoltStore) SelEvents(userId string) ([]types.Event, error) {
+	events := make([]types.Event, 0)
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return rod.SelAll(tx, eventBucket+"."+userId, func() interface{} {
+			return &types.Event{}
+		}, func(v interface{}) {
+			events = append(events, *v.(*types.Event))
+		})
+	})
+
+	return events, err
+}
+
+// UpdateUser saves the new details for this user. The caller should already have validated them (see
+// handlers.decodeForm), so the only error to expect here is ErrUsernameAlreadyExists.
+func (b *BoltStore) UpdateUser(currentUser types.User, updateUser types.UpdateUser) (types.User, error) {
+	var user types.User
+	now := now()
 
 	// Steps:
 	// 1. Get the full user out of the store
@@ -265,15 +368,12 @@ func (b *BoltStore) UpdateUser(currentUser types.User, updateUser types.UpdateUs
 	err := b.db.Update(func(tx *bolt.Tx) error {
 		// get this user - should ALWAYS work if the above Social exists
 		errGetUser := rod.GetJson(tx, userBucket, currentUser.Id, &user)
-		fmt.Printf("Got user = %#v\n", user)
 		if errGetUser != nil {
 			return errGetUser
 		}
 
 		// check to see if the username has changed, and if so, remove the old index entry and add a new one
 		if updateUser.Name != user.Name {
-			fmt.Printf("User is changing their username from %s, to %s\n", user.Name, updateUser.Name)
-
 			// check that this username doesn't already exist
 			id, errGetIndex := rod.GetString(tx, indexUserNameUniqueIndex, updateUser.Name)
 			if errGetIndex != nil {
@@ -294,8 +394,6 @@ func (b *BoltStore) UpdateUser(currentUser types.User, updateUser types.UpdateUs
 			if errPutIndex != nil {
 				return errPutIndex
 			}
-		} else {
-			fmt.Printf("User is NOT changing their username.\n")
 		}
 
 		// update
@@ -306,7 +404,6 @@ func (b *BoltStore) UpdateUser(currentUser types.User, updateUser types.UpdateUs
 
 		// re-save user
 		errPutUser := rod.PutJson(tx, userBucket, user.Id, user)
-		fmt.Printf("errPutUser = %#v\n", errPutUser)
 		if errPutUser != nil {
 			return errPutUser
 		}
@@ -317,6 +414,768 @@ func (b *BoltStore) UpdateUser(currentUser types.User, updateUser types.UpdateUs
 	return user, err
 }
 
+func (b *BoltStore) UnlinkSocial(currentUser types.User, socialId string) (types.User, error) {
+	var user types.User
+	now := now()
+
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		errGetUser := rod.GetJson(tx, userBucket, currentUser.Id, &user)
+		if errGetUser != nil {
+			return errGetUser
+		}
+
+		socialIds, err := removeSocialId(user.SocialIds, socialId)
+		if err != nil {
+			return err
+		}
+
+		// remove the Social itself
+		errDel := rod.Del(tx, socialBucket, socialId)
+		if errDel != nil {
+			return errDel
+		}
+
+		// re-save the user
+		user.SocialIds = socialIds
+		user.Updated = now
+		errPutUser := rod.PutJson(tx, userBucket, user.Id, user)
+		if errPutUser != nil {
+			return errPutUser
+		}
+
+		return putEvent(tx, newEvent(user.Id, types.EventSocialUnlinked, socialId, now))
+	})
+
+	return user, err
+}
+
+func (b *BoltStore) InsPost(currentUser types.User, post types.Post) (types.Post, error) {
+	post.Id, _ = uuid.GenerateUUID()
+	post.UserId = currentUser.Id
+	post.Inserted = now()
+
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		return rod.PutJson(tx, postBucket+"."+post.UserId, postKey(post), post)
+	})
+
+	return post, err
+}
+
+func (b *BoltStore) InsToken(currentUser types.User, token types.Token) (types.Token, string, error) {
+	now := now()
+
+	token, secret, err := newToken(currentUser.Id, token, now)
+	if err != nil {
+		return token, "", err
+	}
+
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		errPut := rod.PutJson(tx, tokenBucket, token.Hash, token)
+		if errPut != nil {
+			return errPut
+		}
+		return putEvent(tx, newEvent(currentUser.Id, types.EventTokenCreated, token.Name, now))
+	})
+	if err != nil {
+		return token, "", err
+	}
+
+	return token, secret, nil
+}
+
+func (b *BoltStore) SelTokens(userId string) ([]types.Token, error) {
+	var tokens []types.Token
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		tokens, err = selTokens(tx, userId)
+		return err
+	})
+
+	return tokens, err
+}
+
+func (b *BoltStore) DelToken(currentUser types.User, tokenId string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		tokens, errSel := selTokens(tx, currentUser.Id)
+		if errSel != nil {
+			return errSel
+		}
+
+		for _, token := range tokens {
+			if token.Id != tokenId {
+				continue
+			}
+			errDel := rod.Del(tx, tokenBucket, token.Hash)
+			if errDel != nil {
+				return errDel
+			}
+			return putEvent(tx, newEvent(currentUser.Id, types.EventTokenRevoked, token.Name, now()))
+		}
+
+		return ErrTokenUnknown
+	})
+}
+
+func (b *BoltStore) GetUserByToken(secret string) (*types.User, *types.Token, error) {
+	var user types.User
+	var token types.Token
+	hash := hashToken(secret)
+
+	errView := b.db.View(func(tx *bolt.Tx) error {
+		errGetToken := rod.GetJson(tx, tokenBucket, hash, &token)
+		if errGetToken != nil {
+			return errGetToken
+		}
+		if token.Id == "" {
+			return nil
+		}
+		return rod.GetJson(tx, userBucket, token.UserId, &user)
+	})
+	if errView != nil {
+		return nil, nil, errView
+	}
+
+	// users waiting to be deleted can't use their tokens
+	if user.Id == "" || !user.DeleteAfter.IsZero() {
+		return nil, nil, nil
+	}
+
+	if touchToken(&token, now()) {
+		errUpdate := b.db.Update(func(tx *bolt.Tx) error {
+			return rod.PutJson(tx, tokenBucket, hash, token)
+		})
+		if errUpdate != nil {
+			return nil, nil, errUpdate
+		}
+	}
+
+	return &user, &token, nil
+}
+
+func (b *BoltStore) InsSession(userId string, session types.Session) (types.Session, error) {
+	session = newSession(userId, session, now())
+
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		return rod.PutJson(tx, sessionBucket, session.Id, session)
+	})
+
+	return session, err
+}
+
+func (b *BoltStore) GetSession(sessionId string) (*types.Session, error) {
+	var session types.Session
+
+	errView := b.db.View(func(tx *bolt.Tx) error {
+		return rod.GetJson(tx, sessionBucket, sessionId, &session)
+	})
+	if errView != nil {
+		return nil, errView
+	}
+	if session.Id == "" {
+		return nil, nil
+	}
+
+	if touchSession(&session, now()) {
+		errUpdate := b.db.Update(func(tx *bolt.Tx) error {
+			return rod.PutJson(tx, sessionBucket, session.Id, session)
+		})
+		if errUpdate != nil {
+			return nil, errUpdate
+		}
+	}
+
+	return &session, nil
+}
+
+func (b *BoltStore) SelSessions(userId string) ([]types.Session, error) {
+	var sessions []types.Session
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		sessions, err = selSessions(tx, userId)
+		return err
+	})
+
+	return sessions, err
+}
+
+func (b *BoltStore) DelSession(currentUser types.User, sessionId string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		var session types.Session
+		errGet := rod.GetJson(tx, sessionBucket, sessionId, &session)
+		if errGet != nil {
+			return errGet
+		}
+		if session.Id == "" || session.UserId != currentUser.Id {
+			return ErrSessionUnknown
+		}
+
+		return rod.Del(tx, sessionBucket, sessionId)
+	})
+}
+
+func (b *BoltStore) DelSessions(currentUser types.User, keepId string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		sessions, errSel := selSessions(tx, currentUser.Id)
+		if errSel != nil {
+			return errSel
+		}
+
+		for _, session := range sessions {
+			if session.Id == keepId {
+				continue
+			}
+			errDel := rod.Del(tx, sessionBucket, session.Id)
+			if errDel != nil {
+				return errDel
+			}
+		}
+
+		return nil
+	})
+}
+
+func (b *BoltStore) PurgeSessions(before time.Time) (int, error) {
+	count := 0
+
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		// firstly, figure out which sessions have been abandoned
+		ids := make([]string, 0)
+		errSelAll := rod.SelAll(tx, sessionBucket, func() interface{} {
+			return &types.Session{}
+		}, func(v interface{}) {
+			session := v.(*types.Session)
+			if session.LastSeen.Before(before) {
+				ids = append(ids, session.Id)
+			}
+		})
+		if errSelAll != nil {
+			return errSelAll
+		}
+
+		// then delete them (we can't delete while iterating over the bucket)
+		for _, id := range ids {
+			errDel := rod.Del(tx, sessionBucket, id)
+			if errDel != nil {
+				return errDel
+			}
+			count++
+		}
+
+		return nil
+	})
+
+	return count, err
+}
+
+func (b *BoltStore) InsAuthState(provider string) (types.AuthState, error) {
+	state := newAuthState(provider, now())
+
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		return rod.PutJson(tx, authStateBucket, state.Id, state)
+	})
+
+	return state, err
+}
+
+func (b *BoltStore) TakeAuthState(stateId string) (*types.AuthState, error) {
+	var state types.AuthState
+
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		errGet := rod.GetJson(tx, authStateBucket, stateId, &state)
+		if errGet != nil {
+			return errGet
+		}
+		if state.Id == "" {
+			return nil
+		}
+		return rod.Del(tx, authStateBucket, stateId)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if state.Id == "" || !now().Before(state.Expires) {
+		return nil, nil
+	}
+
+	return &state, nil
+}
+
+func (b *BoltStore) PurgeAuthStates(now time.Time) (int, error) {
+	count := 0
+
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		// like PurgeSessions(), find the expired attempts before deleting them
+		ids := make([]string, 0)
+		errSelAll := rod.SelAll(tx, authStateBucket, func() interface{} {
+			return &types.AuthState{}
+		}, func(v interface{}) {
+			state := v.(*types.AuthState)
+			if !now.Before(state.Expires) {
+				ids = append(ids, state.Id)
+			}
+		})
+		if errSelAll != nil {
+			return errSelAll
+		}
+
+		for _, id := range ids {
+			errDel := rod.Del(tx, authStateBucket, id)
+			if errDel != nil {
+				return errDel
+			}
+			count++
+		}
+
+		return nil
+	})
+
+	return count, err
+}
+
+func (b *BoltStore) MergeUsers(currentUser types.User, otherUserId string, keep types.MergeUser) (types.User, error) {
+	var user types.User
+	now := now()
+
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		errGetUser := rod.GetJson(tx, userBucket, currentUser.Id, &user)
+		if errGetUser != nil {
+			return errGetUser
+		}
+		var other types.User
+		errGetOther := rod.GetJson(tx, userBucket, otherUserId, &other)
+		if errGetOther != nil {
+			return errGetOther
+		}
+
+		oldName := user.Name
+		errMerge := mergeUser(&user, other, keep, now)
+		if errMerge != nil {
+			return errMerge
+		}
+
+		// re-point all of the other user's socials to this user
+		for _, socialId := range other.SocialIds {
+			var social types.Social
+			errGetSocial := rod.GetJson(tx, socialBucket, socialId, &social)
+			if errGetSocial != nil {
+				return errGetSocial
+			}
+			if social.Id == "" {
+				continue
+			}
+			social.UserId = user.Id
+			social.Updated = now
+			errPutSocial := rod.PutJson(tx, socialBucket, socialId, social)
+			if errPutSocial != nil {
+				return errPutSocial
+			}
+		}
+
+		// move the other user's history over too
+		errMove := moveEvents(tx, other.Id, user.Id)
+		if errMove != nil {
+			return errMove
+		}
+		errMove = movePosts(tx, other.Id, user.Id)
+		if errMove != nil {
+			return errMove
+		}
+		errMove = moveTokens(tx, other.Id, user.Id)
+		if errMove != nil {
+			return errMove
+		}
+		errMove = moveSessions(tx, other.Id, user.Id)
+		if errMove != nil {
+			return errMove
+		}
+
+		// the other user's socials now belong to us, so make sure delUser() doesn't remove them
+		other.SocialIds = nil
+		errPutOther := rod.PutJson(tx, userBucket, other.Id, other)
+		if errPutOther != nil {
+			return errPutOther
+		}
+		errDel := delUser(tx, other.Id)
+		if errDel != nil {
+			return errDel
+		}
+
+		// now that the other username is free, point whichever name we kept at this user
+		if user.Name != oldName {
+			errDelIndex := rod.Del(tx, indexUserNameUniqueIndex, oldName)
+			if errDelIndex != nil {
+				return errDelIndex
+			}
+			errPutIndex := rod.PutString(tx, indexUserNameUniqueIndex, user.Name, user.Id)
+			if errPutIndex != nil {
+				return errPutIndex
+			}
+		}
+
+		errPutUser := rod.PutJson(tx, userBucket, user.Id, user)
+		if errPutUser != nil {
+			return errPutUser
+		}
+
+		return putEvent(tx, newEvent(user.Id, types.EventUserMerged, other.Id, now))
+	})
+
+	return user, err
+}
+
+func (b *BoltStore) DelUser(currentUser types.User) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return delUser(tx, currentUser.Id)
+	})
+}
+
+func (b *BoltStore) ScheduleDelUser(currentUser types.User, deleteAfter time.Time) (types.User, error) {
+	var user types.User
+	now := now()
+
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		errGetUser := rod.GetJson(tx, userBucket, currentUser.Id, &user)
+		if errGetUser != nil {
+			return errGetUser
+		}
+		if user.Id == "" {
+			return ErrUserUnknown
+		}
+
+		user.DeleteAfter = deleteAfter.UTC()
+		user.Updated = now
+		errPutUser := rod.PutJson(tx, userBucket, user.Id, user)
+		if errPutUser != nil {
+			return errPutUser
+		}
+
+		return putEvent(tx, newEvent(user.Id, types.EventUserDeleteScheduled, user.DeleteAfter.Format(time.RFC3339), now))
+	})
+
+	return user, err
+}
+
+func (b *BoltStore) PurgeUsers(now time.Time) ([]string, error) {
+	purged := make([]string, 0)
+
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		// firstly, figure out who needs to go
+		users := make([]types.User, 0)
+		errSelAll := rod.SelAll(tx, userBucket, func() interface{} {
+			return &types.User{}
+		}, func(v interface{}) {
+			users = append(users, *v.(*types.User))
+		})
+		if errSelAll != nil {
+			return errSelAll
+		}
+
+		// then delete them (we can't delete while iterating over the bucket)
+		for _, user := range users {
+			if user.DeleteAfter.IsZero() || user.DeleteAfter.After(now) {
+				continue
+			}
+			errDel := delUser(tx, user.Id)
+			if errDel != nil {
+				return errDel
+			}
+			purged = append(purged, user.Id)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return purged, nil
+}
+
+// ReEncryptSocials decrypts the secrets in every social (with any key in the keyring) and re-encrypts them with the
+// newest key. Use this after adding a new key, before dropping the old ones. It returns how many socials were
+// re-encrypted.
+func (b *BoltStore) ReEncryptSocials() (int, error) {
+	count := 0
+
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		socials := make([]types.Social, 0)
+		errSelAll := rod.SelAll(tx, socialBucket, func() interface{} {
+			return &types.Social{}
+		}, func(v interface{}) {
+			socials = append(socials, *v.(*types.Social))
+		})
+		if errSelAll != nil {
+			return errSelAll
+		}
+
+		for _, social := range socials {
+			errDecrypt := b.keyring.decryptSocial(&social)
+			if errDecrypt != nil {
+				return fmt.Errorf("social %s: %s", social.Id, errDecrypt)
+			}
+			errPutSocial := b.putSocial(tx, social)
+			if errPutSocial != nil {
+				return errPutSocial
+			}
+			count++
+		}
+
+		return nil
+	})
+
+	return count, err
+}
+
+func (b *BoltStore) Dump(w io.Writer) (int64, error) {
+	var n int64
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		n, err = tx.WriteTo(w)
+		return err
+	})
+	return n, err
+}
+
 func (b *BoltStore) GetDB() *bolt.DB {
 	return b.db
 }
+
+// putEvent saves the event into this user's own event bucket, keyed so that they sort in time order.
+func putEvent(tx *bolt.Tx, event types.Event) error {
+	return rod.PutJson(tx, eventBucket+"."+event.UserId, eventKey(event), event)
+}
+
+// delUser removes the user, every social in their SocialIds, their username from the index, and all of their events.
+func delUser(tx *bolt.Tx, userId string) error {
+	var user types.User
+	errGetUser := rod.GetJson(tx, userBucket, userId, &user)
+	if errGetUser != nil {
+		return errGetUser
+	}
+	if user.Id == "" {
+		return ErrUserUnknown
+	}
+
+	for _, socialId := range user.SocialIds {
+		errDel := rod.Del(tx, socialBucket, socialId)
+		if errDel != nil {
+			return errDel
+		}
+	}
+
+	// only remove the username if it is actually pointing at this user
+	id, errGetIndex := rod.GetString(tx, indexUserNameUniqueIndex, user.Name)
+	if errGetIndex != nil {
+		return errGetIndex
+	}
+	if id == user.Id {
+		errDel := rod.Del(tx, indexUserNameUniqueIndex, user.Name)
+		if errDel != nil {
+			return errDel
+		}
+	}
+
+	errDel := rod.Del(tx, userBucket, user.Id)
+	if errDel != nil {
+		return errDel
+	}
+
+	tokens, errSel := selTokens(tx, user.Id)
+	if errSel != nil {
+		return errSel
+	}
+	for _, token := range tokens {
+		errDel := rod.Del(tx, tokenBucket, token.Hash)
+		if errDel != nil {
+			return errDel
+		}
+	}
+
+	// which also logs them out everywhere
+	sessions, errSel := selSessions(tx, user.Id)
+	if errSel != nil {
+		return errSel
+	}
+	for _, session := range sessions {
+		errDel := rod.Del(tx, sessionBucket, session.Id)
+		if errDel != nil {
+			return errDel
+		}
+	}
+
+	// and finally the user's own event and post buckets
+	for _, name := range []string{eventBucket, postBucket} {
+		parent := tx.Bucket([]byte(name))
+		if parent == nil || parent.Bucket([]byte(user.Id)) == nil {
+			continue
+		}
+		errDelBucket := parent.DeleteBucket([]byte(user.Id))
+		if errDelBucket != nil {
+			return errDelBucket
+		}
+	}
+
+	return nil
+}
+
+// moveEvents moves every event from one user's event bucket to another's.
+func moveEvents(tx *bolt.Tx, fromUserId, toUserId string) error {
+	from, err := rod.GetBucket(tx, eventBucket+"."+fromUserId)
+	if err != nil {
+		return err
+	}
+	if from == nil {
+		return nil
+	}
+
+	events := make([]types.Event, 0)
+	errSelAll := rod.SelAll(tx, eventBucket+"."+fromUserId, func() interface{} {
+		return &types.Event{}
+	}, func(v interface{}) {
+		events = append(events, *v.(*types.Event))
+	})
+	if errSelAll != nil {
+		return errSelAll
+	}
+
+	for _, event := range events {
+		event.UserId = toUserId
+		errPut := putEvent(tx, event)
+		if errPut != nil {
+			return errPut
+		}
+	}
+
+	return tx.Bucket([]byte(eventBucket)).DeleteBucket([]byte(fromUserId))
+}
+
+// getSocial reads the social and decrypts its secrets.
+func (b *BoltStore) getSocial(tx *bolt.Tx, socialId string, social *types.Social) error {
+	errGetJson := rod.GetJson(tx, socialBucket, socialId, social)
+	if errGetJson != nil {
+		return errGetJson
+	}
+	return b.keyring.decryptSocial(social)
+}
+
+// putSocial encrypts the social's secrets and saves it.
+func (b *BoltStore) putSocial(tx *bolt.Tx, social types.Social) error {
+	errEncrypt := b.keyring.encryptSocial(&social)
+	if errEncrypt != nil {
+		return errEncrypt
+	}
+	return rod.PutJson(tx, socialBucket, social.Id, social)
+}
+
+// movePosts moves every post from one user's post bucket to another's.
+func movePosts(tx *bolt.Tx, fromUserId, toUserId string) error {
+	from, err := rod.GetBucket(tx, postBucket+"."+fromUserId)
+	if err != nil {
+		return err
+	}
+	if from == nil {
+		return nil
+	}
+
+	posts := make([]types.Post, 0)
+	errSelAll := rod.SelAll(tx, postBucket+"."+fromUserId, func() interface{} {
+		return &types.Post{}
+	}, func(v interface{}) {
+		posts = append(posts, *v.(*types.Post))
+	})
+	if errSelAll != nil {
+		return errSelAll
+	}
+
+	for _, post := range posts {
+		post.UserId = toUserId
+		errPut := rod.PutJson(tx, postBucket+"."+toUserId, postKey(post), post)
+		if errPut != nil {
+			return errPut
+		}
+	}
+
+	return tx.Bucket([]byte(postBucket)).DeleteBucket([]byte(fromUserId))
+}
+
+// selTokens finds all of this user's tokens, oldest first. There won't be many tokens, so we just look through them
+// all rather than keeping an index.
+func selTokens(tx *bolt.Tx, userId string) ([]types.Token, error) {
+	tokens := make([]types.Token, 0)
+	errSelAll := rod.SelAll(tx, tokenBucket, func() interface{} {
+		return &types.Token{}
+	}, func(v interface{}) {
+		token := *v.(*types.Token)
+		if token.UserId == userId {
+			tokens = append(tokens, token)
+		}
+	})
+	if errSelAll != nil {
+		return nil, errSelAll
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].Inserted.Before(tokens[j].Inserted)
+	})
+	return tokens, nil
+}
+
+// moveTokens gives all of one user's tokens to another.
+func moveTokens(tx *bolt.Tx, fromUserId, toUserId string) error {
+	tokens, err := selTokens(tx, fromUserId)
+	if err != nil {
+		return err
+	}
+
+	for _, token := range tokens {
+		token.UserId = toUserId
+		errPut := rod.PutJson(tx, tokenBucket, token.Hash, token)
+		if errPut != nil {
+			return errPut
+		}
+	}
+
+	return nil
+}
+
+// selSessions finds all of this user's sessions, most recently seen first. Like selTokens, this looks through them all
+// rather than keeping an index.
+func selSessions(tx *bolt.Tx, userId string) ([]types.Session, error) {
+	sessions := make([]types.Session, 0)
+	errSelAll := rod.SelAll(tx, sessionBucket, func() interface{} {
+		return &types.Session{}
+	}, func(v interface{}) {
+		session := *v.(*types.Session)
+		if session.UserId == userId {
+			sessions = append(sessions, session)
+		}
+	})
+	if errSelAll != nil {
+		return nil, errSelAll
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen.After(sessions[j].LastSeen)
+	})
+	return sessions, nil
+}
+
+// moveSessions gives all of one user's sessions to another.
+func moveSessions(tx *bolt.Tx, fromUserId, toUserId string) error {
+	sessions, err := selSessions(tx, fromUserId)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		session.UserId = toUserId
+		errPut := rod.PutJson(tx, sessionBucket, session.Id, session)
+		if errPut != nil {
+			return errPut
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,511 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/chilts/rod"
+
+	"internal/types"
+)
+
+func TestBoltStoreEncryptsTokens(t *testing.T) {
+	dir, err := ioutil.TempDir("", "daffy-store-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := path.Join(dir, "daffy.db")
+
+	oldKeyring, _ := NewKeyring([]byte("old-key"))
+	newKeyring, _ := NewKeyring([]byte("new-key"), []byte("old-key"))
+
+	// log in with the old key
+	b := NewBoltStore(filename)
+	b.SetKeyring(oldKeyring)
+	if err := b.Open(); err != nil {
+		t.Fatal(err)
+	}
+	user, err := b.LogIn("", types.SocialLogIn{Provider: "twitter", Id: "123", NickName: "chilts", AccessToken: "plain-token", AccessTokenSecret: "plain-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the tokens aren't in the raw record or the dump
+	raw := rawSocial(t, b, "twitter:123")
+	if bytes.Contains(raw, []byte("plain-token")) || bytes.Contains(raw, []byte("plain-secret")) {
+		t.Errorf("raw social contains plaintext tokens: %s", raw)
+	}
+	dump := &bytes.Buffer{}
+	if _, err := b.Dump(dump); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(dump.Bytes(), []byte("plain-token")) {
+		t.Errorf("dump contains plaintext tokens")
+	}
+
+	// but we get them back
+	assertTokens(t, b, user.SocialIds, "plain-token", "plain-secret")
+	b.Close()
+
+	// rotate to the new key, and everything can still be read
+	b = NewBoltStore(filename)
+	b.SetKeyring(newKeyring)
+	if err := b.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	assertTokens(t, b, user.SocialIds, "plain-token", "plain-secret")
+
+	count, err := b.ReEncryptSocials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("ReEncryptSocials() = %d, want 1", count)
+	}
+
+	// now the old key on its own can't read it, but the new one can
+	b.SetKeyring(oldKeyring)
+	if _, err := b.SelSocials(user.SocialIds); err != ErrUnableToDecrypt {
+		t.Errorf("SelSocials() with old key err = %v, want %v", err, ErrUnableToDecrypt)
+	}
+	onlyNew, _ := NewKeyring([]byte("new-key"))
+	b.SetKeyring(onlyNew)
+	assertTokens(t, b, user.SocialIds, "plain-token", "plain-secret")
+}
+
+func TestKeyringPlaintext(t *testing.T) {
+	keyring, _ := NewKeyring([]byte("key"))
+
+	// values from before encryption was turned on are passed through
+	value, err := keyring.Decrypt("legacy-token")
+	if err != nil || value != "legacy-token" {
+		t.Errorf("Decrypt(legacy) = %q, %v", value, err)
+	}
+
+	// empty stays empty
+	value, err = keyring.Encrypt("")
+	if err != nil || value != "" {
+		t.Errorf("Encrypt(\"\") = %q, %v", value, err)
+	}
+
+	// no keys means no encryption
+	var none *Keyring
+	value, err = none.Encrypt("token")
+	if err != nil || value != "token" {
+		t.Errorf("nil Encrypt() = %q, %v", value, err)
+	}
+}
+
+func rawSocial(t *testing.T, b *BoltStore, socialId string) []byte {
+	var raw []byte
+	err := b.GetDB().View(func(tx *bolt.Tx) error {
+		var err error
+		raw, err = rod.Get(tx, socialBucket, socialId)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func assertTokens(t *testing.T, b *BoltStore, socialIds []string, token, secret string) {
+	socials, err := b.SelSocials(socialIds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if socials[0].AccessToken != token || socials[0].AccessTokenSecret != secret {
+		t.Errorf("social tokens = %q/%q, want %q/%q", socials[0].AccessToken, socials[0].AccessTokenSecret, token, secret)
+	}
+}
+
+func TestBoltStoreMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "daffy-store-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	b := NewBoltStore(path.Join(dir, "daffy.db"))
+	if err := b.OpenNoMigrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// an old social, from before LastLogin existed, in a datastore which has never been migrated
+	updated := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	err = b.GetDB().Update(func(tx *bolt.Tx) error {
+		return rod.PutJson(tx, socialBucket, "twitter:123", types.Social{Id: "twitter:123", Updated: updated})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a dry-run says what would happen, but doesn't do it
+	applied, err := b.Migrate(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(migrations) {
+		t.Errorf("Migrate(dryRun) = %v, want all %d migrations", applied, len(migrations))
+	}
+	if version, _ := b.GetSchemaVersion(); version != 0 {
+		t.Errorf("dry-run changed the schema version to %d", version)
+	}
+	if social := getRawSocial(t, b, "twitter:123"); !social.LastLogin.IsZero() {
+		t.Errorf("dry-run changed the social: %#v", social)
+	}
+
+	// now for real
+	applied, err = b.Migrate(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(migrations) {
+		t.Errorf("Migrate() = %v, want all %d migrations", applied, len(migrations))
+	}
+	if version, _ := b.GetSchemaVersion(); version != SchemaVersion() {
+		t.Errorf("schema version = %d, want %d", version, SchemaVersion())
+	}
+	if social := getRawSocial(t, b, "twitter:123"); !social.LastLogin.Equal(updated) {
+		t.Errorf("social.LastLogin = %v, want %v", social.LastLogin, updated)
+	}
+
+	// and again does nothing
+	applied, err = b.Migrate(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("second Migrate() = %v, want nothing", applied)
+	}
+
+	// a datastore from the future is refused
+	err = b.GetDB().Update(func(tx *bolt.Tx) error {
+		return setSchemaVersion(tx, SchemaVersion()+1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+	if err := b.Open(); err != ErrSchemaTooNew {
+		t.Errorf("Open() err = %v, want %v", err, ErrSchemaTooNew)
+	}
+}
+
+// getRawSocial decodes the social just as it is stored, without decrypting its tokens. A missing social is a zero one.
+func getRawSocial(t *testing.T, b *BoltStore, socialId string) types.Social {
+	var social types.Social
+	if raw := rawSocial(t, b, socialId); raw != nil {
+		if err := json.Unmarshal(raw, &social); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return social
+}
+
+func TestBoltStoreMigrateGPlusToGoogle(t *testing.T) {
+	api, done := newBoltApi(t)
+	defer done()
+	b := api.(*BoltStore)
+
+	// andy only ever logged in with Google+, bob has connected Google since, and carl's Google account is dan's
+	andy := mustLogIn(t, api, "", "gplus", "100", "andy")
+	andy = mustLogIn(t, api, andy.Id, "twitter", "1", "andy")
+	bob := mustLogIn(t, api, "", "gplus", "200", "bob")
+	bob = mustLogIn(t, api, bob.Id, "google", "200", "bob")
+	carl := mustLogIn(t, api, "", "gplus", "300", "carl")
+	dan := mustLogIn(t, api, "", "google", "300", "dan")
+
+	// back to before the migration, with some history
+	err := b.GetDB().Update(func(tx *bolt.Tx) error {
+		if err := putEvent(tx, newEvent(andy.Id, types.EventSocialUnlinked, "gplus:100", now())); err != nil {
+			return err
+		}
+		return setSchemaVersion(tx, 6)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := b.Migrate(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 1 || applied[0] != "rename-gplus-to-google" {
+		t.Errorf("Migrate() = %v", applied)
+	}
+
+	// andy's social has moved, and andy can log in with Google
+	if social := getRawSocial(t, b, "gplus:100"); social.Id != "" {
+		t.Errorf("gplus:100 still exists: %#v", social)
+	}
+	if social := getRawSocial(t, b, "google:100"); social.UserId != andy.Id || social.Provider != "google" || social.NickName != "andy" {
+		t.Errorf("google:100 = %#v", social)
+	}
+	user, _ := b.GetUser(andy.Id)
+	if len(user.SocialIds) != 2 || !contains(user.SocialIds, "google:100") || !contains(user.SocialIds, "twitter:1") {
+		t.Errorf("andy's SocialIds = %v", user.SocialIds)
+	}
+	events, _ := b.SelEvents(andy.Id)
+	if len(events) != 1 || events[0].Detail != "google:100" {
+		t.Errorf("andy's events = %#v", events)
+	}
+	if again := mustLogIn(t, api, "", "google", "100", "andy"); again.Id != andy.Id {
+		t.Errorf("logging in with google:100 gave user %q, want %q", again.Id, andy.Id)
+	}
+
+	// bob's old social is dropped
+	if social := getRawSocial(t, b, "gplus:200"); social.Id != "" {
+		t.Errorf("gplus:200 still exists: %#v", social)
+	}
+	user, _ = b.GetUser(bob.Id)
+	if len(user.SocialIds) != 1 || user.SocialIds[0] != "google:200" {
+		t.Errorf("bob's SocialIds = %v", user.SocialIds)
+	}
+
+	// and carl's is left alone
+	if social := getRawSocial(t, b, "gplus:300"); social.UserId != carl.Id {
+		t.Errorf("gplus:300 = %#v", social)
+	}
+	if social := getRawSocial(t, b, "google:300"); social.UserId != dan.Id {
+		t.Errorf("google:300 = %#v", social)
+	}
+	user, _ = b.GetUser(carl.Id)
+	if len(user.SocialIds) != 1 || user.SocialIds[0] != "gplus:300" {
+		t.Errorf("carl's SocialIds = %v", user.SocialIds)
+	}
+
+	// and everything still agrees with itself
+	if problems, err := b.Check(false); err != nil || len(problems) != 0 {
+		t.Errorf("Check() = %v, %v", problems, err)
+	}
+}
+
+func TestBoltStoreExportImport(t *testing.T) {
+	from, doneFrom := newBoltApi(t)
+	defer doneFrom()
+	to, doneTo := newBoltApi(t)
+	defer doneTo()
+
+	user := mustLogIn(t, from, "", "twitter", "123", "chilts")
+	user = mustLogIn(t, from, user.Id, "github", "456", "chilts")
+	if _, err := from.UnlinkSocial(*user, "github:456"); err != nil {
+		t.Fatal(err)
+	}
+	other := mustLogIn(t, from, "", "gplus", "789", "andy")
+
+	export := &bytes.Buffer{}
+	if err := from.(*BoltStore).Export(export); err != nil {
+		t.Fatal(err)
+	}
+
+	// corrupt the index in the export, since import should rebuild it anyway
+	tampered := bytes.Replace(export.Bytes(), []byte(`"key":"chilts-123","value":"`+user.Id+`"`), []byte(`"key":"chilts-123","value":"nobody"`), 1)
+	if bytes.Equal(tampered, export.Bytes()) {
+		t.Fatalf("expected to find the index record in the export:\n%s", export.Bytes())
+	}
+
+	counts, err := to.(*BoltStore).Import(bytes.NewReader(tampered))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts[exportUser] != 2 || counts[exportSocial] != 2 || counts[exportIndex] != 2 || counts[exportEvent] != 1 {
+		t.Errorf("Import() counts = %v", counts)
+	}
+
+	for _, u := range []*types.User{user, other} {
+		public, err := to.GetUserPublic(u.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if public == nil || public.Id != u.Id {
+			t.Errorf("GetUserPublic(%q) = %#v, want user %q", u.Name, public, u.Id)
+		}
+	}
+	again := mustLogIn(t, to, "", "twitter", "123", "chilts")
+	if again.Id != user.Id {
+		t.Errorf("imported social logs in to %q, want %q", again.Id, user.Id)
+	}
+
+	// can't import on top of existing data
+	if _, err := to.(*BoltStore).Import(bytes.NewReader(export.Bytes())); err != ErrImportNotEmpty {
+		t.Errorf("second Import() err = %v, want %v", err, ErrImportNotEmpty)
+	}
+}
+
+func TestBoltStoreImportNotEmpty(t *testing.T) {
+	empty, doneEmpty := newBoltApi(t)
+	defer doneEmpty()
+	export := &bytes.Buffer{}
+	if err := empty.(*BoltStore).Export(export); err != nil {
+		t.Fatal(err)
+	}
+
+	// anything in any of the buckets Import writes to means the datastore isn't empty
+	for _, bucket := range []string{userBucket, socialBucket, tokenBucket, sessionBucket, indexUserNameUniqueIndex, eventBucket + ".someone", postBucket + ".someone"} {
+		t.Run(bucket, func(t *testing.T) {
+			api, done := newBoltApi(t)
+			defer done()
+			b := api.(*BoltStore)
+
+			err := b.GetDB().Update(func(tx *bolt.Tx) error {
+				return rod.Put(tx, bucket, "key", []byte(`{}`))
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := b.Import(bytes.NewReader(export.Bytes())); err != ErrImportNotEmpty {
+				t.Errorf("Import() err = %v, want %v", err, ErrImportNotEmpty)
+			}
+		})
+	}
+}
+
+func TestBoltStoreCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "daffy-store-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	b := NewBoltStore(path.Join(dir, "daffy.db"))
+	if err := b.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	andy, err := b.LogIn("", types.SocialLogIn{Provider: "twitter", Id: "123", NickName: "andy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.LogIn(andy.Id, types.SocialLogIn{Provider: "github", Id: "456", NickName: "andy"}); err != nil {
+		t.Fatal(err)
+	}
+	bob, err := b.LogIn("", types.SocialLogIn{Provider: "gplus", Id: "789", NickName: "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a clean datastore has no problems
+	problems, err := b.Check(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Fatalf("Check() = %v, want none", problems)
+	}
+
+	// now break it, as a half-finished hand edit might
+	err = b.GetDB().Update(func(tx *bolt.Tx) error {
+		// andy forgets about github, and claims a social which doesn't exist
+		user := types.User{}
+		if err := rod.GetJson(tx, userBucket, andy.Id, &user); err != nil {
+			return err
+		}
+		user.SocialIds = []string{"twitter:123", "twitter:999"}
+		if err := rod.PutJson(tx, userBucket, user.Id, user); err != nil {
+			return err
+		}
+		// a social for a user who has gone
+		if err := rod.PutJson(tx, socialBucket, "twitter:555", types.Social{Id: "twitter:555", UserId: "gone"}); err != nil {
+			return err
+		}
+		// index entries for a user who has gone, and another under the wrong name
+		if err := rod.PutString(tx, indexUserNameUniqueIndex, "ghost", "gone"); err != nil {
+			return err
+		}
+		if err := rod.Del(tx, indexUserNameUniqueIndex, bob.Name); err != nil {
+			return err
+		}
+		if err := rod.PutString(tx, indexUserNameUniqueIndex, "robert", user.Id); err != nil {
+			return err
+		}
+		// a token and a session for a user who has gone
+		if err := rod.PutJson(tx, tokenBucket, "deadbeef", types.Token{Id: "t1", UserId: "gone", Hash: "deadbeef"}); err != nil {
+			return err
+		}
+		if err := rod.PutJson(tx, sessionBucket, "s1", types.Session{Id: "s1", UserId: "gone"}); err != nil {
+			return err
+		}
+		// history left behind
+		return putEvent(tx, newEvent("gone", types.EventSocialUnlinked, "twitter:555", now()))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]bool{
+		ProblemSocialUserMissing:  true,
+		ProblemSocialNotLinked:    true,
+		ProblemUserSocialMissing:  true,
+		ProblemIndexUserMissing:   true,
+		ProblemIndexNameMismatch:  true,
+		ProblemUserNotIndexed:     true,
+		ProblemOrphanBucket:       true,
+		ProblemTokenUserMissing:   true,
+		ProblemSessionUserMissing: true,
+	}
+	problems, err = b.Check(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]bool)
+	for _, problem := range problems {
+		got[problem.Kind] = true
+		if problem.Repaired {
+			t.Errorf("Check(false) repaired %v", problem)
+		}
+	}
+	for kind := range want {
+		if !got[kind] {
+			t.Errorf("Check() missed %s in %v", kind, problems)
+		}
+	}
+
+	// repair everything except the social without a user, which is left for a human
+	problems, err = b.Check(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, problem := range problems {
+		if problem.Kind == ProblemSocialUserMissing {
+			if problem.Repaired {
+				t.Errorf("Check(true) repaired %v", problem)
+			}
+			continue
+		}
+		if !problem.Repaired {
+			t.Errorf("Check(true) didn't repair %v", problem)
+		}
+	}
+	problems, err = b.Check(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 || problems[0].Kind != ProblemSocialUserMissing || problems[0].Key != "twitter:555" {
+		t.Errorf("after repair Check() = %v, want only %s", problems, ProblemSocialUserMissing)
+	}
+	if social := getRawSocial(t, b, "twitter:555"); social.UserId != "gone" {
+		t.Errorf("repair changed the social without a user: %#v", social)
+	}
+
+	user, err := b.GetUser(andy.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(user.SocialIds) != 2 || !contains(user.SocialIds, "github:456") {
+		t.Errorf("user.SocialIds = %v", user.SocialIds)
+	}
+	if user, _ := b.GetUserPublic(bob.Name); user == nil {
+		t.Errorf("bob is not back in the index")
+	}
+}
@@ -0,0 +1,135 @@
+package store
+
+import (
+	"sync"
+	"time"
+
+	"github.com/markbates/goth"
+
+	"internal/types"
+)
+
+// cacheMaxUsers keeps the cache small. Once it is full we just start again, since the cache only needs to cover the
+// users who are busy right now.
+const cacheMaxUsers = 1000
+
+// CacheStore wraps another Api and remembers each user read by GetUser() for a short while, since it is called on every
+// request. Anything which changes a user through the CacheStore forgets them straight away, so the TTL only matters for
+// changes made elsewhere (e.g. `server db import`).
+type CacheStore struct {
+	Api
+	ttl time.Duration
+
+	mu    sync.Mutex
+	users map[string]cachedUser
+}
+
+type cachedUser struct {
+	user    *types.User
+	expires time.Time
+}
+
+// Make sure the CacheStore conforms to the Api interface.
+var _ Api = &CacheStore{}
+
+func NewCacheStore(api Api, ttl time.Duration) *CacheStore {
+	return &CacheStore{
+		Api:   api,
+		ttl:   ttl,
+		users: make(map[string]cachedUser),
+	}
+}
+
+func (c *CacheStore) GetUser(userId string) (*types.User, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	cached, ok := c.users[userId]
+	c.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return copyCachedUser(cached.user), nil
+	}
+
+	user, err := c.Api.GetUser(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	// a user who doesn't exist is remembered too, so they can't be used to hammer the store
+	c.mu.Lock()
+	if len(c.users) >= cacheMaxUsers {
+		c.users = make(map[string]cachedUser)
+	}
+	c.users[userId] = cachedUser{copyCachedUser(user), now.Add(c.ttl)}
+	c.mu.Unlock()
+
+	return user, nil
+}
+
+func (c *CacheStore) LogInGoth(userId, provider string, authUser goth.User) (*types.User, error) {
+	user, err := c.Api.LogInGoth(userId, provider, authUser)
+	c.forget(userId)
+	if user != nil {
+		c.forget(user.Id)
+	}
+	return user, err
+}
+
+func (c *CacheStore) LogIn(userId string, logIn types.SocialLogIn) (*types.User, error) {
+	user, err := c.Api.LogIn(userId, logIn)
+	c.forget(userId)
+	if user != nil {
+		c.forget(user.Id)
+	}
+	return user, err
+}
+
+func (c *CacheStore) UpdateUser(currentUser types.User, data types.UpdateUser) (types.User, error) {
+	defer c.forget(currentUser.Id)
+	return c.Api.UpdateUser(currentUser, data)
+}
+
+func (c *CacheStore) UnlinkSocial(currentUser types.User, socialId string) (types.User, error) {
+	defer c.forget(currentUser.Id)
+	return c.Api.UnlinkSocial(currentUser, socialId)
+}
+
+func (c *CacheStore) MergeUsers(currentUser types.User, otherUserId string, keep types.MergeUser) (types.User, error) {
+	defer c.forget(currentUser.Id, otherUserId)
+	return c.Api.MergeUsers(currentUser, otherUserId, keep)
+}
+
+func (c *CacheStore) DelUser(currentUser types.User) error {
+	defer c.forget(currentUser.Id)
+	return c.Api.DelUser(currentUser)
+}
+
+func (c *CacheStore) ScheduleDelUser(currentUser types.User, deleteAfter time.Time) (types.User, error) {
+	defer c.forget(currentUser.Id)
+	return c.Api.ScheduleDelUser(currentUser, deleteAfter)
+}
+
+func (c *CacheStore) PurgeUsers(now time.Time) ([]string, error) {
+	purged, err := c.Api.PurgeUsers(now)
+	c.forget(purged...)
+	return purged, err
+}
+
+// forget removes these users from the cache, so the next GetUser() reads them from the store again.
+func (c *CacheStore) forget(userIds ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, userId := range userIds {
+		delete(c.users, userId)
+	}
+}
+
+// copyCachedUser makes sure no caller can change what is held in the cache.
+func copyCachedUser(user *types.User) *types.User {
+	if user == nil {
+		return nil
+	}
+	u := copyUser(*user)
+	return &u
+}
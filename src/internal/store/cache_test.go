@@ -0,0 +1,58 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"internal/types"
+)
+
+func TestCacheStoreGetUser(t *testing.T) {
+	mem := NewMemStore()
+	if err := mem.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+	cache := NewCacheStore(mem, 50*time.Millisecond)
+
+	user := mustLogIn(t, cache, "", "twitter", "123", "chilts")
+	if _, err := cache.GetUser(user.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	// changes made through the cache are seen straight away
+	updated, err := cache.UpdateUser(*user, types.UpdateUser{Name: user.Name, Title: "Andy", Email: user.Email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := cache.GetUser(user.Id); got == nil || got.Title != "Andy" {
+		t.Errorf("after UpdateUser() GetUser() = %#v", got)
+	}
+
+	// whereas changes made behind its back are only seen once the entry expires
+	if _, err := mem.UpdateUser(updated, types.UpdateUser{Name: user.Name, Title: "Chilts", Email: user.Email}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := cache.GetUser(user.Id); got == nil || got.Title != "Andy" {
+		t.Errorf("GetUser() = %#v, want the cached copy", got)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if got, _ := cache.GetUser(user.Id); got == nil || got.Title != "Chilts" {
+		t.Errorf("after the TTL GetUser() = %#v, want the new copy", got)
+	}
+
+	// callers can't change what is cached
+	got, _ := cache.GetUser(user.Id)
+	got.SocialIds[0] = "changed"
+	if again, _ := cache.GetUser(user.Id); again.SocialIds[0] == "changed" {
+		t.Errorf("GetUser() shares its SocialIds with the cache")
+	}
+
+	// a deleted user is gone straight away
+	if err := cache.DelUser(*user); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := cache.GetUser(user.Id); got != nil {
+		t.Errorf("after DelUser() GetUser() = %#v, want nil", got)
+	}
+}
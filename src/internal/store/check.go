@@ -0,0 +1,304 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/chilts/rod"
+
+	"internal/types"
+)
+
+// The kinds of Problem that Check() looks for.
+const (
+	ProblemBadRecord          = "bad-record"           // a record which isn't valid JSON
+	ProblemKeyMismatch        = "key-mismatch"         // a user or social stored under a different key to its Id
+	ProblemSocialUserMissing  = "social-user-missing"  // a social whose UserId doesn't exist
+	ProblemSocialNotLinked    = "social-not-linked"    // a social whose user doesn't list it in their SocialIds
+	ProblemUserSocialMissing  = "user-social-missing"  // a user's SocialIds names a social which doesn't exist
+	ProblemUserSocialOwner    = "user-social-owner"    // a user's SocialIds names a social which belongs to someone else
+	ProblemUserNoSocials      = "user-no-socials"      // a user with no socials to log in with
+	ProblemIndexUserMissing   = "index-user-missing"   // an i-u-n-u entry pointing at a user which doesn't exist
+	ProblemIndexNameMismatch  = "index-name-mismatch"  // an i-u-n-u entry pointing at a user with a different name
+	ProblemUserNotIndexed     = "user-not-indexed"     // a user with no i-u-n-u entry for their name
+	ProblemUserNameTaken      = "user-name-taken"      // a user with the same name as another user
+	ProblemOrphanBucket       = "orphan-bucket"        // an event or post bucket for a user which doesn't exist
+	ProblemTokenUserMissing   = "token-user-missing"   // a token whose UserId doesn't exist
+	ProblemSessionUserMissing = "session-user-missing" // a session whose UserId doesn't exist
+)
+
+// Problem is one inconsistency found by Check().
+type Problem struct {
+	Kind     string `json:"kind"`
+	Bucket   string `json:"bucket"`
+	Key      string `json:"key"`
+	Detail   string `json:"detail"`
+	Repaired bool   `json:"repaired"`
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s %s/%s: %s", p.Kind, p.Bucket, p.Key, p.Detail)
+}
+
+// Check walks every bucket looking for records which don't agree with each other. With repair set, it fixes whatever
+// it safely can in the same transaction, always treating a social's UserId as the truth. Anything it can't fix (e.g.
+// bad JSON, two users with the same name, or a social whose user has gone) is left alone for a human to look at.
+func (b *BoltStore) Check(repair bool) ([]Problem, error) {
+	var problems []Problem
+
+	fn := func(tx *bolt.Tx) error {
+		var err error
+		problems, err = check(tx, repair)
+		return err
+	}
+
+	var err error
+	if repair {
+		err = b.db.Update(fn)
+	} else {
+		err = b.db.View(fn)
+	}
+	return problems, err
+}
+
+func check(tx *bolt.Tx, repair bool) ([]Problem, error) {
+	problems := make([]Problem, 0)
+	report := func(kind, bucket, key, detail string, repaired bool) {
+		problems = append(problems, Problem{kind, bucket, key, detail, repaired})
+	}
+
+	// read everything in first, since we can't change a bucket while iterating over it
+	users := make(map[string]*types.User)
+	userIds := make([]string, 0)
+	err := forEachRecord(tx, userBucket, func(k string, v []byte) error {
+		user := types.User{}
+		if err := json.Unmarshal(v, &user); err != nil {
+			report(ProblemBadRecord, userBucket, k, err.Error(), false)
+			return nil
+		}
+		if user.Id != k {
+			report(ProblemKeyMismatch, userBucket, k, fmt.Sprintf("record has Id %q", user.Id), false)
+		}
+		users[k] = &user
+		userIds = append(userIds, k)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	socials := make(map[string]*types.Social)
+	socialIds := make([]string, 0)
+	err = forEachRecord(tx, socialBucket, func(k string, v []byte) error {
+		social := types.Social{}
+		if err := json.Unmarshal(v, &social); err != nil {
+			report(ProblemBadRecord, socialBucket, k, err.Error(), false)
+			return nil
+		}
+		if social.Id != k {
+			report(ProblemKeyMismatch, socialBucket, k, fmt.Sprintf("record has Id %q", social.Id), false)
+		}
+		socials[k] = &social
+		socialIds = append(socialIds, k)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]string)
+	names := make([]string, 0)
+	err = forEachRecord(tx, indexUserNameUniqueIndex, func(k string, v []byte) error {
+		index[k] = string(v)
+		names = append(names, k)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	changed := make(map[string]bool)
+
+	// socials -> users
+	for _, socialId := range socialIds {
+		social := socials[socialId]
+		user, ok := users[social.UserId]
+		if !ok {
+			// never repaired, since deleting it would lose whatever account someone logs in to with it
+			report(ProblemSocialUserMissing, socialBucket, socialId, fmt.Sprintf("user %q does not exist", social.UserId), false)
+			continue
+		}
+		if !contains(user.SocialIds, socialId) {
+			report(ProblemSocialNotLinked, socialBucket, socialId, fmt.Sprintf("user %q does not list it", user.Id), repair)
+			if repair {
+				user.SocialIds = append(user.SocialIds, socialId)
+				changed[user.Id] = true
+			}
+		}
+	}
+
+	// users -> socials
+	for _, userId := range userIds {
+		user := users[userId]
+		keep := make([]string, 0, len(user.SocialIds))
+		for _, socialId := range user.SocialIds {
+			social, ok := socials[socialId]
+			if !ok {
+				report(ProblemUserSocialMissing, userBucket, userId, fmt.Sprintf("social %q does not exist", socialId), repair)
+				continue
+			}
+			if social.UserId != userId {
+				report(ProblemUserSocialOwner, userBucket, userId, fmt.Sprintf("social %q belongs to user %q", socialId, social.UserId), repair)
+				continue
+			}
+			keep = append(keep, socialId)
+		}
+		if len(keep) != len(user.SocialIds) && repair {
+			user.SocialIds = keep
+			changed[userId] = true
+		}
+		if len(keep) == 0 {
+			report(ProblemUserNoSocials, userBucket, userId, "the user has no way to log in", false)
+		}
+	}
+
+	// index -> users
+	for _, name := range names {
+		userId := index[name]
+		user, ok := users[userId]
+		if !ok {
+			report(ProblemIndexUserMissing, indexUserNameUniqueIndex, name, fmt.Sprintf("user %q does not exist", userId), repair)
+		} else if user.Name != name {
+			report(ProblemIndexNameMismatch, indexUserNameUniqueIndex, name, fmt.Sprintf("user %q is named %q", userId, user.Name), repair)
+		} else {
+			continue
+		}
+		if repair {
+			if err := rod.Del(tx, indexUserNameUniqueIndex, name); err != nil {
+				return nil, err
+			}
+			delete(index, name)
+		}
+	}
+
+	// users -> index
+	for _, userId := range userIds {
+		user := users[userId]
+		indexedId, ok := index[user.Name]
+		if !ok {
+			report(ProblemUserNotIndexed, userBucket, userId, fmt.Sprintf("no index entry for %q", user.Name), repair)
+			if repair {
+				if err := rod.PutString(tx, indexUserNameUniqueIndex, user.Name, userId); err != nil {
+					return nil, err
+				}
+				index[user.Name] = userId
+			}
+			continue
+		}
+		if indexedId != userId {
+			report(ProblemUserNameTaken, userBucket, userId, fmt.Sprintf("%q is also used by user %q", user.Name, indexedId), false)
+		}
+	}
+
+	for userId := range changed {
+		user := users[userId]
+		user.Updated = now()
+		if err := rod.PutJson(tx, userBucket, userId, user); err != nil {
+			return nil, err
+		}
+	}
+
+	// tokens and sessions belonging to no-one
+	for _, b := range []struct {
+		bucket, kind string
+		userId       func(v []byte) (string, error)
+	}{
+		{tokenBucket, ProblemTokenUserMissing, func(v []byte) (string, error) {
+			token := types.Token{}
+			err := json.Unmarshal(v, &token)
+			return token.UserId, err
+		}},
+		{sessionBucket, ProblemSessionUserMissing, func(v []byte) (string, error) {
+			session := types.Session{}
+			err := json.Unmarshal(v, &session)
+			return session.UserId, err
+		}},
+	} {
+		orphans := make([]string, 0)
+		err = forEachRecord(tx, b.bucket, func(k string, v []byte) error {
+			userId, err := b.userId(v)
+			if err != nil {
+				report(ProblemBadRecord, b.bucket, k, err.Error(), false)
+				return nil
+			}
+			if users[userId] == nil {
+				orphans = append(orphans, k)
+				report(b.kind, b.bucket, k, fmt.Sprintf("user %q does not exist", userId), repair)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if repair {
+			for _, key := range orphans {
+				if err := rod.Del(tx, b.bucket, key); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	// event and post buckets belonging to no-one
+	for _, name := range []string{eventBucket, postBucket} {
+		parent := tx.Bucket([]byte(name))
+		if parent == nil {
+			continue
+		}
+		orphans := make([]string, 0)
+		err := parent.ForEach(func(k, v []byte) error {
+			if v == nil && users[string(k)] == nil {
+				orphans = append(orphans, string(k))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, userId := range orphans {
+			report(ProblemOrphanBucket, name, userId, "user does not exist", repair)
+			if repair {
+				if err := parent.DeleteBucket([]byte(userId)); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return problems, nil
+}
+
+// forEachRecord calls fn for every key/value in the (top level) bucket, skipping any nested buckets. A missing bucket
+// has no records.
+func forEachRecord(tx *bolt.Tx, name string, fn func(k string, v []byte) error) error {
+	bucket := tx.Bucket([]byte(name))
+	if bucket == nil {
+		return nil
+	}
+	return bucket.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return nil
+		}
+		return fn(string(k), v)
+	})
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,30 @@
+package store
+
+import (
+	"time"
+
+	uuid "github.com/hashicorp/go-uuid"
+
+	"internal/types"
+)
+
+func newEvent(userId, kind, detail string, now time.Time) types.Event {
+	id, _ := uuid.GenerateUUID()
+	return types.Event{
+		Id:       id,
+		UserId:   userId,
+		Kind:     kind,
+		Detail:   detail,
+		Inserted: now,
+	}
+}
+
+// eventKey sorts lexically in the order the events happened.
+func eventKey(event types.Event) string {
+	return event.Inserted.Format("20060102-150405.000000000") + "-" + event.Id
+}
+
+// postKey sorts lexically in the order the posts were made.
+func postKey(post types.Post) string {
+	return post.Inserted.Format("20060102-150405.000000000") + "-" + post.Id
+}
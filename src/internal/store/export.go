@@ -0,0 +1,232 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/chilts/rod"
+
+	"internal/types"
+)
+
+var (
+	ErrImportNoHeader          = errors.New("Import must start with a header record")
+	ErrImportNotEmpty          = errors.New("Can only import into an empty datastore")
+	ErrImportDuplicateUsername = errors.New("Import contains two users with the same username")
+)
+
+// The types of record in an export.
+const (
+	exportHeader  = "header"
+	exportUser    = "user"
+	exportSocial  = "social"
+	exportEvent   = "event"
+	exportPost    = "post"
+	exportToken   = "token"
+	exportSession = "session"
+	exportIndex   = "index"
+)
+
+// exportRecord is one line of an export. The first line is always a header with the SchemaVersion, and every other line
+// is a single key/value from one of the buckets. Values are exported exactly as stored, so any encrypted tokens stay
+// encrypted.
+type exportRecord struct {
+	Type          string          `json:"type"`
+	SchemaVersion int             `json:"schemaVersion,omitempty"`
+	Exported      *time.Time      `json:"exported,omitempty"`
+	Key           string          `json:"key,omitempty"`
+	Value         json.RawMessage `json:"value,omitempty"`
+}
+
+// Export writes the whole datastore to w as newline-delimited JSON. Log in attempts are left out since they only last a
+// few minutes anyway.
+func (b *BoltStore) Export(w io.Writer) error {
+	enc := json.NewEncoder(w)
+
+	return b.db.View(func(tx *bolt.Tx) error {
+		version, err := getSchemaVersion(tx)
+		if err != nil {
+			return err
+		}
+		exported := now()
+		err = enc.Encode(exportRecord{Type: exportHeader, SchemaVersion: version, Exported: &exported})
+		if err != nil {
+			return err
+		}
+
+		exportBucket := func(recordType string, bucket *bolt.Bucket) error {
+			if bucket == nil {
+				return nil
+			}
+			return bucket.ForEach(func(k, v []byte) error {
+				if v == nil {
+					// a nested bucket
+					return nil
+				}
+				value := json.RawMessage(v)
+				if recordType == exportIndex {
+					// index values are plain strings
+					value, _ = json.Marshal(string(v))
+				}
+				return enc.Encode(exportRecord{Type: recordType, Key: string(k), Value: value})
+			})
+		}
+
+		for _, e := range []struct{ recordType, bucket string }{
+			{exportUser, userBucket},
+			{exportSocial, socialBucket},
+			{exportToken, tokenBucket},
+			{exportSession, sessionBucket},
+			{exportIndex, indexUserNameUniqueIndex},
+		} {
+			err := exportBucket(e.recordType, tx.Bucket([]byte(e.bucket)))
+			if err != nil {
+				return err
+			}
+		}
+
+		// events and posts live in a bucket per user
+		for _, e := range []struct{ recordType, bucket string }{
+			{exportEvent, eventBucket},
+			{exportPost, postBucket},
+		} {
+			parent := tx.Bucket([]byte(e.bucket))
+			if parent == nil {
+				continue
+			}
+			err := parent.ForEach(func(k, v []byte) error {
+				return exportBucket(e.recordType, parent.Bucket(k))
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Import loads an export written by Export() into this datastore, which must not have anything in it yet. The username index in the export is ignored
+// and instead rebuilt from the users themselves. An export from an older schema version is migrated once loaded. It
+// returns a count of each type of record read.
+func (b *BoltStore) Import(r io.Reader) (map[string]int, error) {
+	counts := make(map[string]int)
+
+	dec := json.NewDecoder(bufio.NewReader(r))
+
+	// firstly, the header
+	var header exportRecord
+	err := dec.Decode(&header)
+	if err != nil {
+		return counts, err
+	}
+	if header.Type != exportHeader || header.SchemaVersion == 0 {
+		return counts, ErrImportNoHeader
+	}
+	if header.SchemaVersion > SchemaVersion() {
+		return counts, ErrSchemaTooNew
+	}
+
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		// every bucket we write to must be empty, including any per-user buckets of events and posts
+		for _, name := range []string{userBucket, socialBucket, eventBucket, postBucket, tokenBucket, sessionBucket, indexUserNameUniqueIndex} {
+			bucket := tx.Bucket([]byte(name))
+			if bucket == nil {
+				continue
+			}
+			if k, _ := bucket.Cursor().First(); k != nil {
+				return ErrImportNotEmpty
+			}
+		}
+
+		for {
+			var record exportRecord
+			err := dec.Decode(&record)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			counts[record.Type]++
+
+			switch record.Type {
+			case exportUser:
+				err = rod.Put(tx, userBucket, record.Key, record.Value)
+			case exportSocial:
+				err = rod.Put(tx, socialBucket, record.Key, record.Value)
+			case exportToken:
+				err = rod.Put(tx, tokenBucket, record.Key, record.Value)
+			case exportSession:
+				err = rod.Put(tx, sessionBucket, record.Key, record.Value)
+			case exportEvent:
+				var event types.Event
+				err = json.Unmarshal(record.Value, &event)
+				if err == nil {
+					err = rod.Put(tx, eventBucket+"."+event.UserId, record.Key, record.Value)
+				}
+			case exportPost:
+				var post types.Post
+				err = json.Unmarshal(record.Value, &post)
+				if err == nil {
+					err = rod.Put(tx, postBucket+"."+post.UserId, record.Key, record.Value)
+				}
+			case exportIndex:
+				// rebuilt below
+			default:
+				err = fmt.Errorf("unknown record type %q", record.Type)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		errIndex := rebuildUserNameIndex(tx)
+		if errIndex != nil {
+			return errIndex
+		}
+
+		// whatever we imported is at the export's version, so any later migrations still need to run
+		return setSchemaVersion(tx, header.SchemaVersion)
+	})
+	if err != nil {
+		return counts, err
+	}
+
+	_, err = b.Migrate(false)
+	return counts, err
+}
+
+// rebuildUserNameIndex indexes every User.Name from scratch.
+func rebuildUserNameIndex(tx *bolt.Tx) error {
+	users := make([]types.User, 0)
+	errSelAll := rod.SelAll(tx, userBucket, func() interface{} {
+		return &types.User{}
+	}, func(v interface{}) {
+		users = append(users, *v.(*types.User))
+	})
+	if errSelAll != nil {
+		return errSelAll
+	}
+
+	for _, user := range users {
+		id, err := rod.GetString(tx, indexUserNameUniqueIndex, user.Name)
+		if err != nil {
+			return err
+		}
+		if id != "" && id != user.Id {
+			return fmt.Errorf("%s: %q", ErrImportDuplicateUsername, user.Name)
+		}
+		err = rod.PutString(tx, indexUserNameUniqueIndex, user.Name, user.Id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
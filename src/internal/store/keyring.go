@@ -0,0 +1,136 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+
+	"internal/types"
+)
+
+var ErrUnableToDecrypt = errors.New("Unable to decrypt value with any key")
+
+// encryptedPrefix marks a value as having been encrypted by a Keyring. Anything without it is treated as plaintext so
+// that records written before encryption was turned on can still be read.
+const encryptedPrefix = "enc:"
+
+// Keyring encrypts and decrypts secrets such as the OAuth tokens in the social bucket, using AES-256-GCM.
+//
+// It follows the same pattern as the session keys: the first key is used for all new encryption, and every key is
+// tried in turn when decrypting. To rotate, add a new key at the front (keep the old ones for now), re-encrypt
+// everything, and then drop the old keys.
+type Keyring struct {
+	aeads []cipher.AEAD
+}
+
+// NewKeyring creates a Keyring from the keys given, newest first. Empty keys are skipped so that optional environment
+// variables can be passed straight in. Each key is hashed with SHA-256 so it may be any length.
+func NewKeyring(keys ...[]byte) (*Keyring, error) {
+	k := &Keyring{}
+
+	for _, key := range keys {
+		if len(key) == 0 {
+			continue
+		}
+
+		sum := sha256.Sum256(key)
+		block, err := aes.NewCipher(sum[:])
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		k.aeads = append(k.aeads, aead)
+	}
+
+	return k, nil
+}
+
+// Enabled returns true if there is at least one key to encrypt with.
+func (k *Keyring) Enabled() bool {
+	return k != nil && len(k.aeads) > 0
+}
+
+// Encrypt encrypts the value with the newest key. Empty values are left empty, and if there are no keys the value is
+// returned as-is.
+func (k *Keyring) Encrypt(value string) (string, error) {
+	if value == "" || !k.Enabled() {
+		return value, nil
+	}
+
+	aead := k.aeads[0]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(value), nil)
+	return encryptedPrefix + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt decrypts a value produced by Encrypt, trying each key in turn. Values which were never encrypted are returned
+// as-is.
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !strings.HasPrefix(value, encryptedPrefix) {
+		return value, nil
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+	if err != nil {
+		return "", err
+	}
+
+	if k != nil {
+		for _, aead := range k.aeads {
+			if len(sealed) < aead.NonceSize() {
+				continue
+			}
+			nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+			plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+			if err == nil {
+				return string(plaintext), nil
+			}
+		}
+	}
+
+	return "", ErrUnableToDecrypt
+}
+
+// encryptSocial encrypts all of the secrets in the social.
+func (k *Keyring) encryptSocial(social *types.Social) error {
+	for _, field := range socialSecrets(social) {
+		encrypted, err := k.Encrypt(*field)
+		if err != nil {
+			return err
+		}
+		*field = encrypted
+	}
+	return nil
+}
+
+// decryptSocial decrypts all of the secrets in the social.
+func (k *Keyring) decryptSocial(social *types.Social) error {
+	for _, field := range socialSecrets(social) {
+		decrypted, err := k.Decrypt(*field)
+		if err != nil {
+			return err
+		}
+		*field = decrypted
+	}
+	return nil
+}
+
+func socialSecrets(social *types.Social) []*string {
+	return []*string{
+		&social.AccessToken,
+		&social.AccessTokenSecret,
+		&social.RefreshToken,
+	}
+}
@@ -0,0 +1,637 @@
+package store
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	uuid "github.com/hashicorp/go-uuid"
+	"github.com/markbates/goth"
+
+	"internal/types"
+)
+
+// MemStore is an in-memory implementation of the Api. Nothing is persisted, so it is mostly useful for tests and for
+// trying things out locally without a `daffy.db` file.
+type MemStore struct {
+	mu sync.RWMutex
+
+	users    map[string]types.User
+	socials  map[string]types.Social
+	names    map[string]string // the equivalent of the `i-u-n-u` index, username -> userId
+	events   map[string][]types.Event
+	posts    map[string][]types.Post
+	tokens   map[string]types.Token // keyed on the token's Hash, like the BoltStore
+	sessions map[string]types.Session
+	states   map[string]types.AuthState
+}
+
+// Make sure the MemStore conforms to the Api interface.
+var _ Api = &MemStore{}
+
+func NewMemStore() *MemStore {
+	return &MemStore{}
+}
+
+func (m *MemStore) Open() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.users = make(map[string]types.User)
+	m.socials = make(map[string]types.Social)
+	m.names = make(map[string]string)
+	m.events = make(map[string][]types.Event)
+	m.posts = make(map[string][]types.Post)
+	m.tokens = make(map[string]types.Token)
+	m.sessions = make(map[string]types.Session)
+	m.states = make(map[string]types.AuthState)
+	return nil
+}
+
+func (m *MemStore) Close() error {
+	return nil
+}
+
+// Dump writes every user, social, username index entry, event, post, token and session out as one JSON document.
+func (m *MemStore) Dump(w io.Writer) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	data, err := json.Marshal(struct {
+		Users    map[string]types.User
+		Socials  map[string]types.Social
+		Names    map[string]string
+		Events   map[string][]types.Event
+		Posts    map[string][]types.Post
+		Tokens   map[string]types.Token
+		Sessions map[string]types.Session
+	}{
+		m.users,
+		m.socials,
+		m.names,
+		m.events,
+		m.posts,
+		m.tokens,
+		m.sessions,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+func (m *MemStore) LogInGoth(userId, provider string, authUser goth.User) (*types.User, error) {
+	return m.LogIn(userId, socialLogInFromGoth(provider, authUser))
+}
+
+func (m *MemStore) LogIn(userId string, logIn types.SocialLogIn) (*types.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := now()
+	isLoggedIn := userId != ""
+	socialId := logIn.SocialId()
+
+	// check to see if the socialId exists
+	if social, ok := m.socials[socialId]; ok {
+		if isLoggedIn && social.UserId != userId {
+			return nil, ErrSocialAccountAlreadyExists
+		}
+
+		refreshSocial(&social, logIn, now)
+		m.socials[socialId] = social
+
+		// logging back in cancels any pending deletion
+		user := copyUser(m.users[social.UserId])
+		if !user.DeleteAfter.IsZero() {
+			user.DeleteAfter = time.Time{}
+			user.Updated = now
+			m.users[user.Id] = copyUser(user)
+			m.addEvent(newEvent(user.Id, types.EventUserDeleteCancelled, socialId, now))
+		}
+		return &user, nil
+	}
+
+	// if we have a user logged in already, get the user and add this socialId
+	var user types.User
+	if isLoggedIn {
+		user = copyUser(m.users[userId])
+		user.SocialIds = append(user.SocialIds, socialId)
+		user.Updated = now
+	} else {
+		// create a unique userName for this user - they can change it if they like
+		userName, err := newUserName(logIn.NickName, logIn.Id, func(name string) (bool, error) {
+			_, ok := m.names[name]
+			return ok, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// no user, so create a unique UserId for this user (this never changes)
+		userId, _ = uuid.GenerateUUID()
+
+		user = types.User{
+			Id:    userId,
+			Name:  userName,
+			Title: logIn.Title,
+			Email: logIn.Email,
+			SocialIds: []string{
+				socialId,
+			},
+			Inserted: now,
+			Updated:  now,
+		}
+		m.names[user.Name] = userId
+	}
+
+	m.socials[socialId] = newSocial(userId, logIn, now)
+	m.users[userId] = copyUser(user)
+
+	return &user, nil
+}
+
+func (m *MemStore) SelSocials(socialIds []string) ([]types.Social, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	socials := make([]types.Social, 0)
+	for _, socialId := range socialIds {
+		// mirror the BoltStore, which gives back an empty Social for an unknown socialId
+		socials = append(socials, m.socials[socialId])
+	}
+
+	return socials, nil
+}
+
+func (m *MemStore) GetUserPublic(username string) (*types.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	userId, ok := m.names[username]
+	if !ok {
+		return nil, nil
+	}
+
+	// users waiting to be deleted are no longer public
+	user := copyUser(m.users[userId])
+	if !user.DeleteAfter.IsZero() {
+		return nil, nil
+	}
+	return &user, nil
+}
+
+func (m *MemStore) GetUser(userId string) (*types.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.users[userId]
+	if !ok {
+		return nil, nil
+	}
+	user = copyUser(user)
+	return &user, nil
+}
+
+func (m *MemStore) GetUserBySocialId(socialId string) (*types.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	social, ok := m.socials[socialId]
+	if !ok {
+		return nil, nil
+	}
+	user, ok := m.users[social.UserId]
+	if !ok {
+		return nil, nil
+	}
+	user = copyUser(user)
+	return &user, nil
+}
+
+func (m *MemStore) SelPosts(userId string) ([]types.Post, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return append(make([]types.Post, 0), m.posts[userId]...), nil
+}
+
+func (m *MemStore) SelEvents(userId string) ([]types.Event, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return append(make([]types.Event, 0), m.events[userId]...), nil
+}
+
+func (m *MemStore) UpdateUser(currentUser types.User, updateUser types.UpdateUser) (types.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user := copyUser(m.users[currentUser.Id])
+
+	// check to see if the username has changed, and if so, remove the old index entry and add a new one
+	if updateUser.Name != user.Name {
+		if _, ok := m.names[updateUser.Name]; ok {
+			return user, ErrUsernameAlreadyExists
+		}
+		delete(m.names, user.Name)
+		m.names[updateUser.Name] = currentUser.Id
+	}
+
+	user.Name = updateUser.Name
+	user.Title = updateUser.Title
+	user.Email = updateUser.Email
+	user.Updated = now()
+
+	m.users[user.Id] = copyUser(user)
+
+	return user, nil
+}
+
+func (m *MemStore) UnlinkSocial(currentUser types.User, socialId string) (types.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := now()
+	user := copyUser(m.users[currentUser.Id])
+
+	socialIds, err := removeSocialId(user.SocialIds, socialId)
+	if err != nil {
+		return user, err
+	}
+
+	delete(m.socials, socialId)
+
+	user.SocialIds = socialIds
+	user.Updated = now
+	m.users[user.Id] = copyUser(user)
+
+	m.addEvent(newEvent(user.Id, types.EventSocialUnlinked, socialId, now))
+
+	return user, nil
+}
+
+func (m *MemStore) InsPost(currentUser types.User, post types.Post) (types.Post, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	post.Id, _ = uuid.GenerateUUID()
+	post.UserId = currentUser.Id
+	post.Inserted = now()
+	m.posts[post.UserId] = append(m.posts[post.UserId], post)
+
+	return post, nil
+}
+
+func (m *MemStore) InsToken(currentUser types.User, token types.Token) (types.Token, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := now()
+	token, secret, err := newToken(currentUser.Id, token, now)
+	if err != nil {
+		return token, "", err
+	}
+
+	m.tokens[token.Hash] = copyToken(token)
+	m.addEvent(newEvent(currentUser.Id, types.EventTokenCreated, token.Name, now))
+
+	return token, secret, nil
+}
+
+func (m *MemStore) SelTokens(userId string) ([]types.Token, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.selTokens(userId), nil
+}
+
+func (m *MemStore) DelToken(currentUser types.User, tokenId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, token := range m.selTokens(currentUser.Id) {
+		if token.Id == tokenId {
+			delete(m.tokens, token.Hash)
+			m.addEvent(newEvent(currentUser.Id, types.EventTokenRevoked, token.Name, now()))
+			return nil
+		}
+	}
+
+	return ErrTokenUnknown
+}
+
+func (m *MemStore) GetUserByToken(secret string) (*types.User, *types.Token, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	token, ok := m.tokens[hashToken(secret)]
+	if !ok {
+		return nil, nil, nil
+	}
+
+	// users waiting to be deleted can't use their tokens
+	user, ok := m.users[token.UserId]
+	if !ok || !user.DeleteAfter.IsZero() {
+		return nil, nil, nil
+	}
+
+	if touchToken(&token, now()) {
+		m.tokens[token.Hash] = token
+	}
+
+	user = copyUser(user)
+	token = copyToken(token)
+	return &user, &token, nil
+}
+
+func (m *MemStore) InsSession(userId string, session types.Session) (types.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session = newSession(userId, session, now())
+	m.sessions[session.Id] = session
+
+	return session, nil
+}
+
+func (m *MemStore) GetSession(sessionId string) (*types.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[sessionId]
+	if !ok {
+		return nil, nil
+	}
+
+	if touchSession(&session, now()) {
+		m.sessions[session.Id] = session
+	}
+
+	return &session, nil
+}
+
+func (m *MemStore) SelSessions(userId string) ([]types.Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.selSessions(userId), nil
+}
+
+func (m *MemStore) DelSession(currentUser types.User, sessionId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[sessionId]
+	if !ok || session.UserId != currentUser.Id {
+		return ErrSessionUnknown
+	}
+
+	delete(m.sessions, sessionId)
+	return nil
+}
+
+func (m *MemStore) DelSessions(currentUser types.User, keepId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, session := range m.selSessions(currentUser.Id) {
+		if session.Id != keepId {
+			delete(m.sessions, session.Id)
+		}
+	}
+
+	return nil
+}
+
+func (m *MemStore) PurgeSessions(before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := 0
+	for id, session := range m.sessions {
+		if session.LastSeen.Before(before) {
+			delete(m.sessions, id)
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (m *MemStore) InsAuthState(provider string) (types.AuthState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state := newAuthState(provider, now())
+	m.states[state.Id] = state
+
+	return state, nil
+}
+
+func (m *MemStore) TakeAuthState(stateId string) (*types.AuthState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state, ok := m.states[stateId]
+	if !ok {
+		return nil, nil
+	}
+	delete(m.states, stateId)
+
+	if !now().Before(state.Expires) {
+		return nil, nil
+	}
+	return &state, nil
+}
+
+func (m *MemStore) PurgeAuthStates(now time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := 0
+	for id, state := range m.states {
+		if !now.Before(state.Expires) {
+			delete(m.states, id)
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (m *MemStore) MergeUsers(currentUser types.User, otherUserId string, keep types.MergeUser) (types.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := now()
+	user := copyUser(m.users[currentUser.Id])
+	other := copyUser(m.users[otherUserId])
+
+	oldName := user.Name
+	if err := mergeUser(&user, other, keep, now); err != nil {
+		return user, err
+	}
+
+	for _, socialId := range other.SocialIds {
+		if social, ok := m.socials[socialId]; ok {
+			social.UserId = user.Id
+			social.Updated = now
+			m.socials[socialId] = social
+		}
+	}
+
+	for _, event := range m.events[other.Id] {
+		event.UserId = user.Id
+		m.addEvent(event)
+	}
+	for _, post := range m.posts[other.Id] {
+		post.UserId = user.Id
+		m.posts[user.Id] = append(m.posts[user.Id], post)
+	}
+	for _, token := range m.selTokens(other.Id) {
+		token.UserId = user.Id
+		m.tokens[token.Hash] = token
+	}
+	for _, session := range m.selSessions(other.Id) {
+		session.UserId = user.Id
+		m.sessions[session.Id] = session
+	}
+
+	// the other user's socials now belong to us, so make sure delUser() doesn't remove them
+	other.SocialIds = nil
+	m.users[other.Id] = other
+	if err := m.delUser(other.Id); err != nil {
+		return user, err
+	}
+
+	if user.Name != oldName {
+		delete(m.names, oldName)
+		m.names[user.Name] = user.Id
+	}
+	m.users[user.Id] = copyUser(user)
+	m.addEvent(newEvent(user.Id, types.EventUserMerged, other.Id, now))
+
+	return user, nil
+}
+
+func (m *MemStore) DelUser(currentUser types.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.delUser(currentUser.Id)
+}
+
+func (m *MemStore) ScheduleDelUser(currentUser types.User, deleteAfter time.Time) (types.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[currentUser.Id]
+	if !ok {
+		return user, ErrUserUnknown
+	}
+
+	now := now()
+	user = copyUser(user)
+	user.DeleteAfter = deleteAfter.UTC()
+	user.Updated = now
+	m.users[user.Id] = copyUser(user)
+	m.addEvent(newEvent(user.Id, types.EventUserDeleteScheduled, user.DeleteAfter.Format(time.RFC3339), now))
+
+	return user, nil
+}
+
+func (m *MemStore) PurgeUsers(now time.Time) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	purged := make([]string, 0)
+	for id, user := range m.users {
+		if user.DeleteAfter.IsZero() || user.DeleteAfter.After(now) {
+			continue
+		}
+		if err := m.delUser(id); err != nil {
+			return nil, err
+		}
+		purged = append(purged, id)
+	}
+
+	return purged, nil
+}
+
+// delUser must be called with the lock held.
+func (m *MemStore) delUser(userId string) error {
+	user, ok := m.users[userId]
+	if !ok {
+		return ErrUserUnknown
+	}
+
+	for _, socialId := range user.SocialIds {
+		delete(m.socials, socialId)
+	}
+	if m.names[user.Name] == user.Id {
+		delete(m.names, user.Name)
+	}
+	delete(m.users, user.Id)
+	delete(m.events, user.Id)
+	delete(m.posts, user.Id)
+	for _, token := range m.selTokens(user.Id) {
+		delete(m.tokens, token.Hash)
+	}
+	for _, session := range m.selSessions(user.Id) {
+		delete(m.sessions, session.Id)
+	}
+
+	return nil
+}
+
+// addEvent must be called with the lock held.
+func (m *MemStore) addEvent(event types.Event) {
+	m.events[event.UserId] = append(m.events[event.UserId], event)
+}
+
+// selTokens must be called with the lock held.
+func (m *MemStore) selTokens(userId string) []types.Token {
+	tokens := make([]types.Token, 0)
+	for _, token := range m.tokens {
+		if token.UserId == userId {
+			tokens = append(tokens, copyToken(token))
+		}
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].Inserted.Before(tokens[j].Inserted)
+	})
+	return tokens
+}
+
+// selSessions must be called with the lock held.
+func (m *MemStore) selSessions(userId string) []types.Session {
+	sessions := make([]types.Session, 0)
+	for _, session := range m.sessions {
+		if session.UserId == userId {
+			sessions = append(sessions, session)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen.After(sessions[j].LastSeen)
+	})
+	return sessions
+}
+
+// copyUser makes sure callers never share the SocialIds slice with what is held in the store.
+func copyUser(user types.User) types.User {
+	user.SocialIds = append([]string(nil), user.SocialIds...)
+	return user
+}
+
+// copyToken does the same as copyUser, for the Scopes.
+func copyToken(token types.Token) types.Token {
+	token.Scopes = append([]string(nil), token.Scopes...)
+	return token
+}
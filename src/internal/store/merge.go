@@ -0,0 +1,37 @@
+package store
+
+import (
+	"time"
+
+	"internal/types"
+)
+
+// mergeUser checks that each of the details in keep came from one of the two users, then folds other into user. It is
+// up to the caller to re-point the other user's socials, fix the username index, and remove the other user.
+func mergeUser(user *types.User, other types.User, keep types.MergeUser, now time.Time) error {
+	if user.Id == "" || other.Id == "" {
+		return ErrUserUnknown
+	}
+	if user.Id == other.Id {
+		return ErrMergeSameUser
+	}
+
+	if keep.Name != user.Name && keep.Name != other.Name {
+		return ErrMergeInvalid
+	}
+	if keep.Title != user.Title && keep.Title != other.Title {
+		return ErrMergeInvalid
+	}
+	if keep.Email != user.Email && keep.Email != other.Email {
+		return ErrMergeInvalid
+	}
+
+	user.Name = keep.Name
+	user.Title = keep.Title
+	user.Email = keep.Email
+	user.SocialIds = append(user.SocialIds, other.SocialIds...)
+	user.DeleteAfter = time.Time{}
+	user.Updated = now
+
+	return nil
+}
@@ -0,0 +1,333 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/chilts/rod"
+
+	"internal/types"
+)
+
+var (
+	ErrSchemaTooNew = errors.New("Database schema is newer than this server knows about")
+
+	// errDryRun is returned from inside the transaction to make sure a dry-run is rolled back.
+	errDryRun = errors.New("dry-run")
+)
+
+var metaBucket = "meta"
+var metaSchemaVersionKey = "schema-version"
+
+// migration is one step in upgrading the datastore. Each is run in its own transaction along with the update to the
+// schema version, so either both happen or neither does.
+type migration struct {
+	version int
+	name    string
+	fn      func(tx *bolt.Tx) error
+}
+
+// migrations must be in version order. Once released, never change or remove a migration, just add a new one to the
+// end.
+var migrations = []migration{
+	{1, "create-buckets", migrateCreateBuckets},
+	{2, "social-last-login", migrateSocialLastLogin},
+	{3, "create-post-bucket", migrateCreatePostBucket},
+	{4, "create-token-bucket", migrateCreateTokenBucket},
+	{5, "create-session-bucket", migrateCreateSessionBucket},
+	{6, "create-auth-state-bucket", migrateCreateAuthStateBucket},
+	{7, "rename-gplus-to-google", migrateRenameGPlusToGoogle},
+}
+
+// SchemaVersion returns the version of the schema this code expects, ie. the version of the latest migration.
+func SchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// Migrate brings the datastore up to the latest SchemaVersion() and returns the names of the migrations which were
+// run. It refuses to touch a datastore with a schema newer than we know about.
+//
+// With dryRun, every pending migration is run in a single transaction which is then rolled back, so you can check they
+// would succeed without changing anything.
+func (b *BoltStore) Migrate(dryRun bool) ([]string, error) {
+	applied := make([]string, 0)
+
+	if dryRun {
+		err := b.db.Update(func(tx *bolt.Tx) error {
+			version, err := getSchemaVersion(tx)
+			if err != nil {
+				return err
+			}
+			if version > SchemaVersion() {
+				return ErrSchemaTooNew
+			}
+
+			for _, m := range migrations {
+				if m.version <= version {
+					continue
+				}
+				errMigrate := runMigration(tx, m)
+				if errMigrate != nil {
+					return errMigrate
+				}
+				applied = append(applied, m.name)
+			}
+
+			return errDryRun
+		})
+		if err != errDryRun {
+			return nil, err
+		}
+		return applied, nil
+	}
+
+	for _, m := range migrations {
+		ran := false
+		err := b.db.Update(func(tx *bolt.Tx) error {
+			version, err := getSchemaVersion(tx)
+			if err != nil {
+				return err
+			}
+			if version > SchemaVersion() {
+				return ErrSchemaTooNew
+			}
+			if m.version <= version {
+				return nil
+			}
+
+			ran = true
+			return runMigration(tx, m)
+		})
+		if err != nil {
+			return applied, err
+		}
+		if ran {
+			log.Printf("Migrated datastore to version %d (%s)\n", m.version, m.name)
+			applied = append(applied, m.name)
+		}
+	}
+
+	return applied, nil
+}
+
+// GetSchemaVersion returns the schema version the datastore is currently at.
+func (b *BoltStore) GetSchemaVersion() (int, error) {
+	var version int
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		version, err = getSchemaVersion(tx)
+		return err
+	})
+	return version, err
+}
+
+func runMigration(tx *bolt.Tx, m migration) error {
+	errMigrate := m.fn(tx)
+	if errMigrate != nil {
+		return errMigrate
+	}
+	return setSchemaVersion(tx, m.version)
+}
+
+// getSchemaVersion returns 0 for a datastore which has never been migrated.
+func getSchemaVersion(tx *bolt.Tx) (int, error) {
+	str, err := rod.GetString(tx, metaBucket, metaSchemaVersionKey)
+	if err != nil {
+		return 0, err
+	}
+	if str == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(str)
+}
+
+func setSchemaVersion(tx *bolt.Tx, version int) error {
+	return rod.PutString(tx, metaBucket, metaSchemaVersionKey, strconv.Itoa(version))
+}
+
+// migrateCreateBuckets makes sure all of the top-level buckets exist.
+func migrateCreateBuckets(tx *bolt.Tx) error {
+	for _, name := range []string{userBucket, socialBucket, eventBucket, indexUserNameUniqueIndex} {
+		_, err := tx.CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateSocialLastLogin fills in Social.LastLogin for socials created before it existed. The best guess we have is
+// the last time the social was updated.
+func migrateSocialLastLogin(tx *bolt.Tx) error {
+	socials := make([]types.Social, 0)
+	errSelAll := rod.SelAll(tx, socialBucket, func() interface{} {
+		return &types.Social{}
+	}, func(v interface{}) {
+		socials = append(socials, *v.(*types.Social))
+	})
+	if errSelAll != nil {
+		return errSelAll
+	}
+
+	for _, social := range socials {
+		if !social.LastLogin.IsZero() {
+			continue
+		}
+		// Note: the secrets are still encrypted here, and are written back untouched.
+		social.LastLogin = social.Updated
+		errPutJson := rod.PutJson(tx, socialBucket, social.Id, social)
+		if errPutJson != nil {
+			return errPutJson
+		}
+	}
+
+	return nil
+}
+
+// migrateCreatePostBucket adds the bucket which holds each user's posts.
+func migrateCreatePostBucket(tx *bolt.Tx) error {
+	_, err := tx.CreateBucketIfNotExists([]byte(postBucket))
+	return err
+}
+
+// migrateCreateTokenBucket adds the bucket which holds everyone's personal access tokens.
+func migrateCreateTokenBucket(tx *bolt.Tx) error {
+	_, err := tx.CreateBucketIfNotExists([]byte(tokenBucket))
+	return err
+}
+
+// migrateCreateSessionBucket adds the bucket which holds everyone's logged in sessions.
+func migrateCreateSessionBucket(tx *bolt.Tx) error {
+	_, err := tx.CreateBucketIfNotExists([]byte(sessionBucket))
+	return err
+}
+
+// migrateCreateAuthStateBucket adds the bucket which holds log in attempts which are still in progress.
+func migrateCreateAuthStateBucket(tx *bolt.Tx) error {
+	_, err := tx.CreateBucketIfNotExists([]byte(authStateBucket))
+	return err
+}
+
+// migrateRenameGPlusToGoogle turns every `gplus:<id>` social into `google:<id>`, along with the user's SocialIds and
+// any events which mention it. Google+ has been shut down, but Google's OpenID Connect `sub` is the same account id it
+// used, so this lets those people carry on logging in with Google.
+//
+// If the user has already connected that Google account again, their old social is just dropped. If someone else has,
+// the old one is left as it is (and logged) for a human to sort out.
+func migrateRenameGPlusToGoogle(tx *bolt.Tx) error {
+	socials := make([]types.Social, 0)
+	errSelAll := rod.SelAll(tx, socialBucket, func() interface{} {
+		return &types.Social{}
+	}, func(v interface{}) {
+		social := *v.(*types.Social)
+		if strings.HasPrefix(social.Id, "gplus:") {
+			socials = append(socials, social)
+		}
+	})
+	if errSelAll != nil {
+		return errSelAll
+	}
+
+	renamed := make(map[string]string)
+	for _, social := range socials {
+		oldId := social.Id
+		newId := "google:" + strings.TrimPrefix(oldId, "gplus:")
+
+		var existing types.Social
+		err := rod.GetJson(tx, socialBucket, newId, &existing)
+		if err != nil {
+			return err
+		}
+		if existing.Id != "" && existing.UserId != social.UserId {
+			log.Printf("Not renaming social %s, since %s belongs to user %s\n", oldId, newId, existing.UserId)
+			continue
+		}
+
+		// Note: the secrets are still encrypted here, and are written back untouched.
+		if existing.Id == "" {
+			social.Id = newId
+			social.Provider = "google"
+			err = rod.PutJson(tx, socialBucket, newId, social)
+			if err != nil {
+				return err
+			}
+		}
+		err = rod.Del(tx, socialBucket, oldId)
+		if err != nil {
+			return err
+		}
+		renamed[oldId] = newId
+
+		var user types.User
+		err = rod.GetJson(tx, userBucket, social.UserId, &user)
+		if err != nil {
+			return err
+		}
+		if user.Id == "" {
+			continue
+		}
+		socialIds := make([]string, 0, len(user.SocialIds))
+		for _, socialId := range user.SocialIds {
+			if socialId == oldId {
+				socialId = newId
+			}
+			if !contains(socialIds, socialId) {
+				socialIds = append(socialIds, socialId)
+			}
+		}
+		user.SocialIds = socialIds
+		err = rod.PutJson(tx, userBucket, user.Id, user)
+		if err != nil {
+			return err
+		}
+	}
+	if len(renamed) == 0 {
+		return nil
+	}
+
+	// events live in a bucket per user, which can't be changed while we're iterating over it
+	parent := tx.Bucket([]byte(eventBucket))
+	if parent == nil {
+		return nil
+	}
+	userIds := make([]string, 0)
+	err := parent.ForEach(func(k, v []byte) error {
+		if v == nil {
+			userIds = append(userIds, string(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, userId := range userIds {
+		location := eventBucket + "." + userId
+		changed := make(map[string]types.Event)
+		err := parent.Bucket([]byte(userId)).ForEach(func(k, v []byte) error {
+			var event types.Event
+			if err := json.Unmarshal(v, &event); err != nil {
+				return err
+			}
+			if newId, ok := renamed[event.Detail]; ok {
+				event.Detail = newId
+				changed[string(k)] = event
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for key, event := range changed {
+			err := rod.PutJson(tx, location, key, event)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,31 @@
+package store
+
+import (
+	"time"
+
+	uuid "github.com/hashicorp/go-uuid"
+
+	"internal/types"
+)
+
+// sessionLastSeenInterval stops every single page view from being a write, since we only need a rough idea of when a
+// session was last seen.
+const sessionLastSeenInterval = 1 * time.Minute
+
+// newSession fills in the rest of the Session.
+func newSession(userId string, session types.Session, now time.Time) types.Session {
+	session.Id, _ = uuid.GenerateUUID()
+	session.UserId = userId
+	session.LastSeen = now
+	session.Inserted = now
+	return session
+}
+
+// touchSession updates LastSeen, and says whether the session needs saving.
+func touchSession(session *types.Session, now time.Time) bool {
+	if now.Sub(session.LastSeen) < sessionLastSeenInterval {
+		return false
+	}
+	session.LastSeen = now
+	return true
+}
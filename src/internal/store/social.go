@@ -0,0 +1,83 @@
+package store
+
+import (
+	"time"
+
+	"github.com/markbates/goth"
+
+	"internal/types"
+)
+
+// socialLogInFromGoth converts what Goth gives us back from a provider into a types.SocialLogIn.
+func socialLogInFromGoth(provider string, authUser goth.User) types.SocialLogIn {
+	return types.SocialLogIn{
+		Provider:          provider,
+		Id:                authUser.UserID,
+		NickName:          authUser.NickName,
+		Title:             authUser.Name,
+		Email:             authUser.Email,
+		AvatarURL:         authUser.AvatarURL,
+		AccessToken:       authUser.AccessToken,
+		AccessTokenSecret: authUser.AccessTokenSecret,
+		RefreshToken:      authUser.RefreshToken,
+		ExpiresAt:         authUser.ExpiresAt,
+	}
+}
+
+// newSocial creates the Social for a first time log in.
+func newSocial(userId string, logIn types.SocialLogIn, now time.Time) types.Social {
+	return types.Social{
+		Id:                logIn.SocialId(),
+		UserId:            userId,
+		Provider:          logIn.Provider,
+		NickName:          logIn.NickName,
+		Title:             logIn.Title,
+		Email:             logIn.Email,
+		AccessToken:       logIn.AccessToken,
+		AccessTokenSecret: logIn.AccessTokenSecret,
+		RefreshToken:      logIn.RefreshToken,
+		ExpiresAt:         logIn.ExpiresAt,
+		AvatarURL:         logIn.AvatarURL,
+		LastLogin:         now,
+		Inserted:          now,
+		Updated:           now,
+	}
+}
+
+// refreshSocial updates an existing Social with the latest tokens and profile details from the provider.
+func refreshSocial(social *types.Social, logIn types.SocialLogIn, now time.Time) {
+	social.NickName = logIn.NickName
+	social.Title = logIn.Title
+	social.Email = logIn.Email
+	social.AvatarURL = logIn.AvatarURL
+	social.AccessToken = logIn.AccessToken
+	social.AccessTokenSecret = logIn.AccessTokenSecret
+	social.ExpiresAt = logIn.ExpiresAt
+
+	// some providers (e.g. Google) only hand out a refresh token the first time, so don't throw the old one away
+	if logIn.RefreshToken != "" {
+		social.RefreshToken = logIn.RefreshToken
+	}
+
+	social.LastLogin = now
+	social.Updated = now
+}
+
+// removeSocialId returns socialIds without socialId, so long as it was there and it isn't the last one left.
+func removeSocialId(socialIds []string, socialId string) ([]string, error) {
+	remaining := make([]string, 0, len(socialIds))
+	for _, id := range socialIds {
+		if id != socialId {
+			remaining = append(remaining, id)
+		}
+	}
+
+	if len(remaining) == len(socialIds) {
+		return nil, ErrSocialUnknown
+	}
+	if len(remaining) == 0 {
+		return nil, ErrLastSocial
+	}
+
+	return remaining, nil
+}
@@ -1,23 +1,73 @@
 package store
 
 import (
-	"internal/types"
+	"io"
+	"time"
 
 	"github.com/markbates/goth"
+
+	"internal/types"
 )
 
 type Api interface {
 	Open() error
 	Close() error
 
-	// socialId is just "twitter-123456", "facebook-777", or "github-13579"
+	// Dump writes a complete snapshot of the store to w, e.g. for periodic backups.
+	Dump(w io.Writer) (int64, error)
+
+	// Logging in with an existing social refreshes its tokens and profile details. The socialId we store is just
+	// "twitter:123456", "facebook:777", or "github:13579".
 	LogInGoth(userId, provider string, authUser goth.User) (*types.User, error)
-	LogIn(userId, provider, socialId, socialUserName, title, email, accessToken, accessTokenSecret string) (*types.User, error)
-	SelSocials(socialIds []string) ([]types.Social, error) // ToDo: check if this should be in the API
+	LogIn(userId string, logIn types.SocialLogIn) (*types.User, error)
 
 	// The following API are public and don't require a `currentUser`.
 	GetUserPublic(username string) (*types.User, error)
+	SelSocials(socialIds []string) ([]types.Social, error)
+
+	// The following API are for internal use, such as finding the other user when accounts need merging.
+	GetUser(userId string) (*types.User, error)
+	GetUserBySocialId(socialId string) (*types.User, error)
+
+	// A user's history, oldest first.
+	SelPosts(userId string) ([]types.Post, error)
+	SelEvents(userId string) ([]types.Event, error)
 
 	// The following API calls require a `currentUser` so we know the user is authenticated.
 	UpdateUser(currentUser types.User, data types.UpdateUser) (types.User, error)
+	UnlinkSocial(currentUser types.User, socialId string) (types.User, error)
+	InsPost(currentUser types.User, post types.Post) (types.Post, error)
+
+	// Personal access tokens. InsToken() gives back the token's secret, which is the only time it is available, and
+	// GetUserByToken() looks the secret up again (giving nil if it isn't valid) and records that it was used.
+	InsToken(currentUser types.User, token types.Token) (types.Token, string, error)
+	SelTokens(userId string) ([]types.Token, error)
+	DelToken(currentUser types.User, tokenId string) error
+	GetUserByToken(secret string) (*types.User, *types.Token, error)
+
+	// Sessions, one for each browser the user is logged in on. GetSession() gives nil for a session which has been
+	// deleted (i.e. logged out) and records that it was seen. DelSessions() logs out everywhere apart from keepId, and
+	// PurgeSessions() removes any sessions which haven't been seen since before.
+	InsSession(userId string, session types.Session) (types.Session, error)
+	GetSession(sessionId string) (*types.Session, error)
+	SelSessions(userId string) ([]types.Session, error)
+	DelSession(currentUser types.User, sessionId string) error
+	DelSessions(currentUser types.User, keepId string) error
+	PurgeSessions(before time.Time) (int, error)
+
+	// Log in attempts, so the provider's callback can be checked against one we started. TakeAuthState() removes the
+	// attempt so it can only be used once, and gives nil if it is unknown or has expired.
+	InsAuthState(provider string) (types.AuthState, error)
+	TakeAuthState(stateId string) (*types.AuthState, error)
+	PurgeAuthStates(now time.Time) (int, error)
+
+	// Merging moves all of the other user's socials (and events, posts, tokens and sessions) over to the currentUser,
+	// keeps whichever details were chosen in `keep`, then removes the other user.
+	MergeUsers(currentUser types.User, otherUserId string, keep types.MergeUser) (types.User, error)
+
+	// Deleting a user removes the user, all of their socials and their username. ScheduleDelUser() just marks the user
+	// to be deleted by a later PurgeUsers(), and logging in again before then cancels it.
+	DelUser(currentUser types.User) error
+	ScheduleDelUser(currentUser types.User, deleteAfter time.Time) (types.User, error)
+	PurgeUsers(now time.Time) ([]string, error)
 }
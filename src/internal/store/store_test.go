@@ -0,0 +1,932 @@
+package store
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"internal/types"
+)
+
+// newApiFn should return a freshly opened and empty store, plus a func to clean it up afterwards.
+type newApiFn func(t *testing.T) (Api, func())
+
+func newBoltApi(t *testing.T) (Api, func()) {
+	dir, err := ioutil.TempDir("", "daffy-store-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api := NewBoltStore(path.Join(dir, "daffy.db"))
+	if err := api.Open(); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return api, func() {
+		api.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func newMemApi(t *testing.T) (Api, func()) {
+	api := NewMemStore()
+	if err := api.Open(); err != nil {
+		t.Fatal(err)
+	}
+	return api, func() { api.Close() }
+}
+
+func newCacheApi(t *testing.T) (Api, func()) {
+	api, done := newMemApi(t)
+	return NewCacheStore(api, time.Minute), done
+}
+
+func TestBoltStore(t *testing.T) {
+	testApi(t, newBoltApi)
+}
+
+func TestMemStore(t *testing.T) {
+	testApi(t, newMemApi)
+}
+
+func TestCacheStore(t *testing.T) {
+	testApi(t, newCacheApi)
+}
+
+// testApi is the conformance suite which every implementation of the Api should pass. Add any new backend as a
+// `TestXxxStore()` above.
+func testApi(t *testing.T, newApi newApiFn) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, api Api)
+	}{
+		{"LogInCreatesUser", testLogInCreatesUser},
+		{"LogInLinksSocial", testLogInLinksSocial},
+		{"LogInSocialOwnedByAnotherUser", testLogInSocialOwnedByAnotherUser},
+		{"LogInRefreshesSocial", testLogInRefreshesSocial},
+		{"LogInUniqueUserName", testLogInUniqueUserName},
+		{"LogInValidUserName", testLogInValidUserName},
+		{"UpdateUserName", testUpdateUserName},
+		{"UpdateUserNameAlreadyExists", testUpdateUserNameAlreadyExists},
+		{"UnlinkSocial", testUnlinkSocial},
+		{"GetUser", testGetUser},
+		{"MergeUsers", testMergeUsers},
+		{"History", testHistory},
+		{"Tokens", testTokens},
+		{"Sessions", testSessions},
+		{"AuthStates", testAuthStates},
+		{"DelUser", testDelUser},
+		{"ScheduleDelUser", testScheduleDelUser},
+		{"Dump", testDump},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api, done := newApi(t)
+			defer done()
+			test.fn(t, api)
+		})
+	}
+}
+
+func mustLogIn(t *testing.T, api Api, userId, provider, id, nickName string) *types.User {
+	user, err := api.LogIn(userId, types.SocialLogIn{
+		Provider:          provider,
+		Id:                id,
+		NickName:          nickName,
+		Title:             "Title of " + nickName,
+		Email:             nickName + "@example.com",
+		AccessToken:       "token",
+		AccessTokenSecret: "secret",
+	})
+	if err != nil {
+		t.Fatalf("LogIn(%q, %q, %q): %v", userId, provider, id, err)
+	}
+	if user == nil {
+		t.Fatalf("LogIn(%q, %q, %q): returned no user", userId, provider, id)
+	}
+	return user
+}
+
+func testLogInCreatesUser(t *testing.T, api Api) {
+	user := mustLogIn(t, api, "", "twitter", "123", "chilts")
+
+	if user.Id == "" {
+		t.Errorf("expected a user id to be generated")
+	}
+	if user.Name != "chilts-123" {
+		t.Errorf("user.Name = %q, want %q", user.Name, "chilts-123")
+	}
+	if len(user.SocialIds) != 1 || user.SocialIds[0] != "twitter:123" {
+		t.Errorf("user.SocialIds = %v, want [twitter:123]", user.SocialIds)
+	}
+
+	// logging in again gives back the same user
+	again := mustLogIn(t, api, "", "twitter", "123", "chilts")
+	if again.Id != user.Id {
+		t.Errorf("second LogIn() gave user %q, want %q", again.Id, user.Id)
+	}
+
+	// the user is now public
+	public, err := api.GetUserPublic("chilts-123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if public == nil || public.Id != user.Id {
+		t.Errorf("GetUserPublic() = %#v, want user %q", public, user.Id)
+	}
+
+	// and the social was saved
+	socials, err := api.SelSocials(user.SocialIds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(socials) != 1 || socials[0].UserId != user.Id || socials[0].AccessToken != "token" {
+		t.Errorf("SelSocials() = %#v", socials)
+	}
+
+	// unknown users are nil
+	unknown, err := api.GetUserPublic("nobody")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unknown != nil {
+		t.Errorf("GetUserPublic(nobody) = %#v, want nil", unknown)
+	}
+}
+
+func testLogInLinksSocial(t *testing.T, api Api) {
+	user := mustLogIn(t, api, "", "twitter", "123", "chilts")
+	linked := mustLogIn(t, api, user.Id, "github", "456", "chilts")
+
+	if linked.Id != user.Id {
+		t.Errorf("linked user = %q, want %q", linked.Id, user.Id)
+	}
+	if linked.Name != user.Name {
+		t.Errorf("linking changed the username from %q to %q", user.Name, linked.Name)
+	}
+	if len(linked.SocialIds) != 2 || linked.SocialIds[1] != "github:456" {
+		t.Errorf("user.SocialIds = %v, want [twitter:123 github:456]", linked.SocialIds)
+	}
+
+	// logging in with the second social gives back the same user
+	again := mustLogIn(t, api, "", "github", "456", "chilts")
+	if again.Id != user.Id {
+		t.Errorf("LogIn() with linked social gave user %q, want %q", again.Id, user.Id)
+	}
+}
+
+func testLogInSocialOwnedByAnotherUser(t *testing.T, api Api) {
+	first := mustLogIn(t, api, "", "twitter", "123", "chilts")
+	second := mustLogIn(t, api, "", "github", "456", "andy")
+
+	_, err := api.LogIn(second.Id, types.SocialLogIn{Provider: "twitter", Id: "123", NickName: "chilts"})
+	if err != ErrSocialAccountAlreadyExists {
+		t.Fatalf("LogIn() err = %v, want %v", err, ErrSocialAccountAlreadyExists)
+	}
+
+	// neither user should have changed
+	public, err := api.GetUserPublic(first.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(public.SocialIds) != 1 {
+		t.Errorf("first user.SocialIds = %v", public.SocialIds)
+	}
+	public, err = api.GetUserPublic(second.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(public.SocialIds) != 1 {
+		t.Errorf("second user.SocialIds = %v", public.SocialIds)
+	}
+}
+
+func testLogInRefreshesSocial(t *testing.T, api Api) {
+	user := mustLogIn(t, api, "", "twitter", "123", "chilts")
+
+	socials, err := api.SelSocials(user.SocialIds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := socials[0]
+	if first.LastLogin.IsZero() {
+		t.Errorf("expected LastLogin to be set on a new social")
+	}
+
+	expiresAt := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	again, err := api.LogIn("", types.SocialLogIn{
+		Provider:          "twitter",
+		Id:                "123",
+		NickName:          "andychilton",
+		Title:             "Andrew Chilton",
+		Email:             "andy@example.com",
+		AvatarURL:         "https://example.com/avatar.png",
+		AccessToken:       "new-token",
+		AccessTokenSecret: "new-secret",
+		RefreshToken:      "refresh",
+		ExpiresAt:         expiresAt,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Id != user.Id {
+		t.Fatalf("re-login gave user %q, want %q", again.Id, user.Id)
+	}
+
+	socials, err = api.SelSocials(user.SocialIds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	social := socials[0]
+	if social.AccessToken != "new-token" || social.AccessTokenSecret != "new-secret" || social.RefreshToken != "refresh" {
+		t.Errorf("tokens not refreshed: %#v", social)
+	}
+	if social.NickName != "andychilton" || social.Title != "Andrew Chilton" || social.Email != "andy@example.com" || social.AvatarURL != "https://example.com/avatar.png" {
+		t.Errorf("profile not refreshed: %#v", social)
+	}
+	if !social.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("social.ExpiresAt = %v, want %v", social.ExpiresAt, expiresAt)
+	}
+	if social.LastLogin.Before(first.LastLogin) || social.Updated.Before(first.Updated) {
+		t.Errorf("LastLogin/Updated went backwards: %#v", social)
+	}
+	if !social.Inserted.Equal(first.Inserted) {
+		t.Errorf("social.Inserted changed from %v to %v", first.Inserted, social.Inserted)
+	}
+
+	// a provider which doesn't send the refresh token again shouldn't wipe it out
+	if _, err := api.LogIn("", types.SocialLogIn{Provider: "twitter", Id: "123", NickName: "andychilton", AccessToken: "newer-token"}); err != nil {
+		t.Fatal(err)
+	}
+	socials, err = api.SelSocials(user.SocialIds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if socials[0].AccessToken != "newer-token" || socials[0].RefreshToken != "refresh" {
+		t.Errorf("after a third login, social = %#v", socials[0])
+	}
+}
+
+func testLogInUniqueUserName(t *testing.T, api Api) {
+	// someone takes the name that the next new user would be given
+	squatter := mustLogIn(t, api, "", "github", "456", "andy")
+	if _, err := api.UpdateUser(*squatter, types.UpdateUser{Name: "chilts-123", Title: "Andy", Email: "andy@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	user := mustLogIn(t, api, "", "twitter", "123", "chilts")
+	if user.Name != "chilts-123-2" {
+		t.Errorf("user.Name = %q, want %q", user.Name, "chilts-123-2")
+	}
+
+	// both users are still reachable from the index
+	public, err := api.GetUserPublic("chilts-123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if public == nil || public.Id != squatter.Id {
+		t.Errorf("GetUserPublic(chilts-123) = %#v, want user %q", public, squatter.Id)
+	}
+	public, err = api.GetUserPublic("chilts-123-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if public == nil || public.Id != user.Id {
+		t.Errorf("GetUserPublic(chilts-123-2) = %#v, want user %q", public, user.Id)
+	}
+}
+
+func testLogInValidUserName(t *testing.T, api Api) {
+	tests := []struct {
+		provider string
+		id       string
+		nickName string
+		want     string
+	}{
+		{"twitter", "42", "99 Problems", "user-99-problems-42"},
+		{"github", "1234567890", "a-really-quite-long-nickname-indeed", "a-really-quite-long-nickname-ind"},
+		{"gplus", "1234567891", "a-really-quite-long-nickname-indeed", "a-really-quite-long-nickname-i-2"},
+		{"twitter", "7", "", "user-7"},
+	}
+
+	for _, test := range tests {
+		user := mustLogIn(t, api, "", test.provider, test.id, test.nickName)
+		if user.Name != test.want {
+			t.Errorf("LogIn(%q, %q) gave username %q, want %q", test.id, test.nickName, user.Name, test.want)
+		}
+		if !types.IsValidUserName(user.Name) {
+			t.Errorf("LogIn(%q, %q) gave invalid username %q", test.id, test.nickName, user.Name)
+		}
+	}
+}
+
+func testUpdateUserName(t *testing.T, api Api) {
+	user := mustLogIn(t, api, "", "twitter", "123", "chilts")
+
+	updated, err := api.UpdateUser(*user, types.UpdateUser{Name: "andy", Title: "Andrew", Email: "andy@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Name != "andy" || updated.Title != "Andrew" || updated.Email != "andy@example.com" {
+		t.Errorf("UpdateUser() = %#v", updated)
+	}
+
+	// the old name is free and the new name points to this user
+	old, err := api.GetUserPublic("chilts-123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old != nil {
+		t.Errorf("old username still points to %#v", old)
+	}
+	public, err := api.GetUserPublic("andy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if public == nil || public.Id != user.Id {
+		t.Errorf("GetUserPublic(andy) = %#v, want user %q", public, user.Id)
+	}
+
+	// and someone else can now take the old name
+	other := mustLogIn(t, api, "", "github", "456", "andy")
+	if _, err := api.UpdateUser(*other, types.UpdateUser{Name: "chilts-123", Title: "Other", Email: "other@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testUpdateUserNameAlreadyExists(t *testing.T, api Api) {
+	first := mustLogIn(t, api, "", "twitter", "123", "chilts")
+	second := mustLogIn(t, api, "", "github", "456", "andy")
+
+	_, err := api.UpdateUser(*second, types.UpdateUser{Name: first.Name, Title: "Andy", Email: "andy@example.com"})
+	if err != ErrUsernameAlreadyExists {
+		t.Fatalf("UpdateUser() err = %v, want %v", err, ErrUsernameAlreadyExists)
+	}
+
+	// the index should still point at the original owner
+	public, err := api.GetUserPublic(first.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if public == nil || public.Id != first.Id {
+		t.Errorf("GetUserPublic(%q) = %#v, want user %q", first.Name, public, first.Id)
+	}
+}
+
+func testUnlinkSocial(t *testing.T, api Api) {
+	user := mustLogIn(t, api, "", "twitter", "123", "chilts")
+	user = mustLogIn(t, api, user.Id, "github", "456", "chilts")
+
+	// can't unlink something which isn't ours
+	other := mustLogIn(t, api, "", "gplus", "789", "andy")
+	if _, err := api.UnlinkSocial(*user, other.SocialIds[0]); err != ErrSocialUnknown {
+		t.Errorf("UnlinkSocial(someone else's) err = %v, want %v", err, ErrSocialUnknown)
+	}
+
+	unlinked, err := api.UnlinkSocial(*user, "twitter:123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unlinked.SocialIds) != 1 || unlinked.SocialIds[0] != "github:456" {
+		t.Errorf("user.SocialIds = %v, want [github:456]", unlinked.SocialIds)
+	}
+
+	// the social has gone
+	socials, err := api.SelSocials([]string{"twitter:123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if socials[0].Id != "" {
+		t.Errorf("SelSocials() still has %#v", socials[0])
+	}
+
+	// and can't remove the last one
+	if _, err := api.UnlinkSocial(unlinked, "github:456"); err != ErrLastSocial {
+		t.Errorf("UnlinkSocial(last) err = %v, want %v", err, ErrLastSocial)
+	}
+
+	// logging in with the unlinked social now gives a brand new user
+	again := mustLogIn(t, api, "", "twitter", "123", "chilts")
+	if again.Id == user.Id {
+		t.Errorf("unlinked social still logs in to user %q", user.Id)
+	}
+}
+
+func testGetUser(t *testing.T, api Api) {
+	user := mustLogIn(t, api, "", "twitter", "123", "chilts")
+
+	got, err := api.GetUser(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.Name != user.Name {
+		t.Errorf("GetUser() = %#v, want %q", got, user.Name)
+	}
+
+	got, err = api.GetUserBySocialId("twitter:123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.Id != user.Id {
+		t.Errorf("GetUserBySocialId() = %#v, want %q", got, user.Id)
+	}
+
+	for _, fn := range []func() (*types.User, error){
+		func() (*types.User, error) { return api.GetUser("unknown") },
+		func() (*types.User, error) { return api.GetUserBySocialId("twitter:unknown") },
+	} {
+		got, err := fn()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != nil {
+			t.Errorf("expected no user, got %#v", got)
+		}
+	}
+}
+
+func testMergeUsers(t *testing.T, api Api) {
+	user := mustLogIn(t, api, "", "twitter", "123", "chilts")
+	other := mustLogIn(t, api, "", "github", "456", "andy")
+	other = mustLogIn(t, api, other.Id, "gplus", "789", "andy")
+
+	// can't keep details which belong to neither user
+	_, err := api.MergeUsers(*user, other.Id, types.MergeUser{Name: "someone-else", Title: user.Title, Email: user.Email})
+	if err != ErrMergeInvalid {
+		t.Errorf("MergeUsers() err = %v, want %v", err, ErrMergeInvalid)
+	}
+	_, err = api.MergeUsers(*user, user.Id, types.MergeUser{Name: user.Name, Title: user.Title, Email: user.Email})
+	if err != ErrMergeSameUser {
+		t.Errorf("MergeUsers(self) err = %v, want %v", err, ErrMergeSameUser)
+	}
+
+	// keep the other username, but our own title and email
+	merged, err := api.MergeUsers(*user, other.Id, types.MergeUser{Name: other.Name, Title: user.Title, Email: other.Email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.Id != user.Id || merged.Name != other.Name || merged.Title != user.Title || merged.Email != other.Email {
+		t.Errorf("MergeUsers() = %#v", merged)
+	}
+	if len(merged.SocialIds) != 3 {
+		t.Errorf("merged.SocialIds = %v, want all 3", merged.SocialIds)
+	}
+
+	// the other user has gone, and their username now points at us
+	gone, err := api.GetUser(other.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gone != nil {
+		t.Errorf("GetUser(other) = %#v, want nil", gone)
+	}
+	public, err := api.GetUserPublic(other.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if public == nil || public.Id != user.Id {
+		t.Errorf("GetUserPublic(%q) = %#v, want user %q", other.Name, public, user.Id)
+	}
+	public, err = api.GetUserPublic(user.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if public != nil {
+		t.Errorf("old username %q still points at %#v", user.Name, public)
+	}
+
+	// all the socials log in to the merged user
+	for _, social := range []struct{ provider, id string }{{"twitter", "123"}, {"github", "456"}, {"gplus", "789"}} {
+		again := mustLogIn(t, api, "", social.provider, social.id, "andy")
+		if again.Id != user.Id {
+			t.Errorf("LogIn(%s:%s) gave user %q, want %q", social.provider, social.id, again.Id, user.Id)
+		}
+	}
+}
+
+func testHistory(t *testing.T, api Api) {
+	user := mustLogIn(t, api, "", "twitter", "123", "chilts")
+	user = mustLogIn(t, api, user.Id, "github", "456", "chilts")
+
+	for _, text := range []string{"First!", "Second"} {
+		post, err := api.InsPost(*user, types.Post{SocialId: "twitter:123", ProviderId: "1", Text: text})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if post.Id == "" || post.UserId != user.Id || post.Inserted.IsZero() {
+			t.Errorf("InsPost() = %#v", post)
+		}
+	}
+	if _, err := api.UnlinkSocial(*user, "github:456"); err != nil {
+		t.Fatal(err)
+	}
+
+	posts, err := api.SelPosts(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 2 || posts[0].Text != "First!" || posts[1].Text != "Second" {
+		t.Errorf("SelPosts() = %#v", posts)
+	}
+
+	events, err := api.SelEvents(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Kind != types.EventSocialUnlinked || events[0].Detail != "github:456" {
+		t.Errorf("SelEvents() = %#v", events)
+	}
+
+	// merging moves history, deleting removes it
+	other := mustLogIn(t, api, "", "gplus", "789", "andy")
+	if _, err := api.InsPost(*other, types.Post{SocialId: "gplus:789", Text: "Other"}); err != nil {
+		t.Fatal(err)
+	}
+	merged, err := api.MergeUsers(*user, other.Id, types.MergeUser{Name: user.Name, Title: user.Title, Email: user.Email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	posts, err = api.SelPosts(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 3 {
+		t.Errorf("after merge SelPosts() = %#v", posts)
+	}
+
+	if err := api.DelUser(merged); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{user.Id, other.Id} {
+		posts, err := api.SelPosts(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		events, err := api.SelEvents(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(posts) != 0 || len(events) != 0 {
+			t.Errorf("user %q still has history: %v %v", id, posts, events)
+		}
+	}
+}
+
+func testTokens(t *testing.T, api Api) {
+	user := mustLogIn(t, api, "", "twitter", "123", "chilts")
+
+	token, secret, err := api.InsToken(*user, types.Token{Name: "Backups", Scopes: []string{types.TokenScopeRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Id == "" || token.UserId != user.Id || token.Inserted.IsZero() || !token.LastUsed.IsZero() {
+		t.Errorf("InsToken() = %#v", token)
+	}
+	if !strings.HasPrefix(secret, tokenPrefix) || token.Hash == "" || strings.Contains(token.Hash, secret) {
+		t.Errorf("InsToken() secret = %q, hash = %q", secret, token.Hash)
+	}
+
+	// the secret gets us the user, and records that it was used
+	found, foundToken, err := api.GetUserByToken(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found == nil || found.Id != user.Id || foundToken == nil || foundToken.Id != token.Id {
+		t.Fatalf("GetUserByToken() = %#v, %#v", found, foundToken)
+	}
+	if !foundToken.HasScope(types.TokenScopeRead) || foundToken.HasScope(types.TokenScopeWrite) {
+		t.Errorf("token scopes = %v", foundToken.Scopes)
+	}
+	tokens, err := api.SelTokens(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 || tokens[0].LastUsed.IsZero() {
+		t.Errorf("SelTokens() = %#v", tokens)
+	}
+
+	// anything else doesn't
+	for _, bad := range []string{"", "nope", secret + "x", token.Hash} {
+		found, _, err := api.GetUserByToken(bad)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found != nil {
+			t.Errorf("GetUserByToken(%q) = %#v, want nil", bad, found)
+		}
+	}
+
+	// only the owner can revoke it
+	other := mustLogIn(t, api, "", "github", "456", "andy")
+	if err := api.DelToken(*other, token.Id); err != ErrTokenUnknown {
+		t.Errorf("DelToken() by another user err = %v, want %v", err, ErrTokenUnknown)
+	}
+	if err := api.DelToken(*user, token.Id); err != nil {
+		t.Fatal(err)
+	}
+	if found, _, _ := api.GetUserByToken(secret); found != nil {
+		t.Errorf("revoked token still works")
+	}
+	if err := api.DelToken(*user, token.Id); err != ErrTokenUnknown {
+		t.Errorf("DelToken() again err = %v, want %v", err, ErrTokenUnknown)
+	}
+
+	// merging moves tokens over, and deleting removes them
+	_, otherSecret, err := api.InsToken(*other, types.Token{Name: "Other", Scopes: []string{types.TokenScopeWrite}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged, err := api.MergeUsers(*user, other.Id, types.MergeUser{Name: user.Name, Title: user.Title, Email: user.Email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found, _, _ := api.GetUserByToken(otherSecret); found == nil || found.Id != user.Id {
+		t.Errorf("after merge GetUserByToken() = %#v, want user %q", found, user.Id)
+	}
+	if err := api.DelUser(merged); err != nil {
+		t.Fatal(err)
+	}
+	if found, _, _ := api.GetUserByToken(otherSecret); found != nil {
+		t.Errorf("token still works after the user was deleted")
+	}
+}
+
+func testSessions(t *testing.T, api Api) {
+	user := mustLogIn(t, api, "", "twitter", "123", "chilts")
+
+	first, err := api.InsSession(user.Id, types.Session{UserAgent: "Firefox", IP: "203.0.113.7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Id == "" || first.UserId != user.Id || first.UserAgent != "Firefox" || first.Inserted.IsZero() || first.LastSeen.IsZero() {
+		t.Errorf("InsSession() = %#v", first)
+	}
+	second, err := api.InsSession(user.Id, types.Session{UserAgent: "Chrome"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	third, err := api.InsSession(user.Id, types.Session{UserAgent: "Safari"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := api.GetSession(first.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found == nil || found.UserId != user.Id {
+		t.Errorf("GetSession(%q) = %#v", first.Id, found)
+	}
+	if found, _ := api.GetSession("nope"); found != nil {
+		t.Errorf("GetSession(unknown) = %#v, want nil", found)
+	}
+
+	sessions, err := api.SelSessions(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 3 {
+		t.Fatalf("SelSessions() = %d sessions, want 3", len(sessions))
+	}
+
+	// only the owner can log a session out
+	other := mustLogIn(t, api, "", "github", "456", "andy")
+	if err := api.DelSession(*other, first.Id); err != ErrSessionUnknown {
+		t.Errorf("DelSession() by another user err = %v, want %v", err, ErrSessionUnknown)
+	}
+	if err := api.DelSession(*user, first.Id); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := api.GetSession(first.Id); found != nil {
+		t.Errorf("deleted session still found")
+	}
+	if err := api.DelSession(*user, first.Id); err != ErrSessionUnknown {
+		t.Errorf("DelSession() again err = %v, want %v", err, ErrSessionUnknown)
+	}
+
+	// logging out everywhere else keeps just the one
+	otherSession, err := api.InsSession(other.Id, types.Session{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := api.DelSessions(*user, third.Id); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := api.GetSession(second.Id); found != nil {
+		t.Errorf("DelSessions() left session %q", second.Id)
+	}
+	if found, _ := api.GetSession(third.Id); found == nil {
+		t.Errorf("DelSessions() removed the kept session")
+	}
+	if found, _ := api.GetSession(otherSession.Id); found == nil {
+		t.Errorf("DelSessions() removed another user's session")
+	}
+
+	// merging moves sessions over, and deleting removes them
+	merged, err := api.MergeUsers(*user, other.Id, types.MergeUser{Name: user.Name, Title: user.Title, Email: user.Email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := api.GetSession(otherSession.Id); found == nil || found.UserId != user.Id {
+		t.Errorf("after merge GetSession() = %#v, want user %q", found, user.Id)
+	}
+	if err := api.DelUser(merged); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := api.GetSession(third.Id); found != nil {
+		t.Errorf("session still exists after the user was deleted")
+	}
+
+	// abandoned sessions get purged
+	old, err := api.InsSession(user.Id, types.Session{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := api.PurgeSessions(time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("PurgeSessions() = %d, want 1", count)
+	}
+	if found, _ := api.GetSession(old.Id); found != nil {
+		t.Errorf("purged session still found")
+	}
+}
+
+func testAuthStates(t *testing.T, api Api) {
+	state, err := api.InsAuthState("twitter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Id == "" || state.Provider != "twitter" || !state.Expires.After(state.Inserted) {
+		t.Errorf("InsAuthState() = %#v", state)
+	}
+
+	// each attempt can only be used once
+	found, err := api.TakeAuthState(state.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found == nil || found.Id != state.Id || found.Provider != "twitter" {
+		t.Errorf("TakeAuthState(%q) = %#v", state.Id, found)
+	}
+	if found, _ := api.TakeAuthState(state.Id); found != nil {
+		t.Errorf("TakeAuthState() again = %#v, want nil", found)
+	}
+	if found, _ := api.TakeAuthState("nope"); found != nil {
+		t.Errorf("TakeAuthState(unknown) = %#v, want nil", found)
+	}
+
+	// expired attempts get purged
+	old, err := api.InsAuthState("github")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := api.InsAuthState("gplus"); err != nil {
+		t.Fatal(err)
+	}
+	count, err := api.PurgeAuthStates(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("PurgeAuthStates(now) = %d, want 0", count)
+	}
+	count, err = api.PurgeAuthStates(old.Expires.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("PurgeAuthStates() = %d, want 2", count)
+	}
+	if found, _ := api.TakeAuthState(old.Id); found != nil {
+		t.Errorf("purged attempt still found")
+	}
+}
+
+func testDelUser(t *testing.T, api Api) {
+	user := mustLogIn(t, api, "", "twitter", "123", "chilts")
+	user = mustLogIn(t, api, user.Id, "github", "456", "chilts")
+	other := mustLogIn(t, api, "", "gplus", "789", "andy")
+
+	if err := api.DelUser(*user); err != nil {
+		t.Fatal(err)
+	}
+
+	// the username is gone
+	public, err := api.GetUserPublic(user.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if public != nil {
+		t.Errorf("GetUserPublic(%q) = %#v, want nil", user.Name, public)
+	}
+
+	// and so are the socials
+	socials, err := api.SelSocials(user.SocialIds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, social := range socials {
+		if social.Id != "" {
+			t.Errorf("social %q still exists", social.Id)
+		}
+	}
+
+	// but other users are untouched
+	public, err = api.GetUserPublic(other.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if public == nil || public.Id != other.Id {
+		t.Errorf("GetUserPublic(%q) = %#v, want user %q", other.Name, public, other.Id)
+	}
+
+	// deleting again is an error
+	if err := api.DelUser(*user); err != ErrUserUnknown {
+		t.Errorf("second DelUser() err = %v, want %v", err, ErrUserUnknown)
+	}
+}
+
+func testScheduleDelUser(t *testing.T, api Api) {
+	user := mustLogIn(t, api, "", "twitter", "123", "chilts")
+	other := mustLogIn(t, api, "", "github", "456", "andy")
+
+	deleteAfter := time.Now().Add(time.Hour)
+	if _, err := api.ScheduleDelUser(*user, deleteAfter); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := api.ScheduleDelUser(*other, deleteAfter); err != nil {
+		t.Fatal(err)
+	}
+
+	// pending users are hidden
+	public, err := api.GetUserPublic(user.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if public != nil {
+		t.Errorf("GetUserPublic(%q) = %#v, want nil", user.Name, public)
+	}
+
+	// nothing to purge yet
+	purged, err := api.PurgeUsers(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(purged) != 0 {
+		t.Errorf("PurgeUsers() purged %v too early", purged)
+	}
+
+	// logging in again cancels the deletion
+	mustLogIn(t, api, "", "github", "456", "andy")
+	public, err = api.GetUserPublic(other.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if public == nil || !public.DeleteAfter.IsZero() {
+		t.Errorf("GetUserPublic(%q) = %#v, want the user back", other.Name, public)
+	}
+
+	// and after the grace period, only the first user is purged (read first, so any cache has them)
+	if _, err := api.GetUser(user.Id); err != nil {
+		t.Fatal(err)
+	}
+	purged, err = api.PurgeUsers(deleteAfter.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(purged) != 1 || purged[0] != user.Id {
+		t.Errorf("PurgeUsers() = %v, want [%s]", purged, user.Id)
+	}
+	if got, err := api.GetUser(user.Id); got != nil || err != nil {
+		t.Errorf("GetUser() after purging = %#v, %v", got, err)
+	}
+	socials, err := api.SelSocials(user.SocialIds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if socials[0].Id != "" {
+		t.Errorf("social %q still exists", socials[0].Id)
+	}
+}
+
+func testDump(t *testing.T, api Api) {
+	mustLogIn(t, api, "", "twitter", "123", "chilts")
+
+	buf := &bytes.Buffer{}
+	n, err := api.Dump(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 || n != int64(buf.Len()) {
+		t.Errorf("Dump() wrote %d bytes but reported %d", buf.Len(), n)
+	}
+}
@@ -0,0 +1,52 @@
+package store
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	uuid "github.com/hashicorp/go-uuid"
+
+	"internal/types"
+)
+
+// tokenPrefix makes our tokens easy to recognise, e.g. by secret scanners.
+const tokenPrefix = "daffy_"
+
+// tokenLastUsedInterval stops every single API request from being a write, since we only need a rough idea of when a
+// token was last used.
+const tokenLastUsedInterval = 1 * time.Minute
+
+// newToken fills in the rest of the Token and gives back the secret, which is the only time it is ever seen.
+func newToken(userId string, token types.Token, now time.Time) (types.Token, string, error) {
+	random := make([]byte, 32)
+	_, err := rand.Read(random)
+	if err != nil {
+		return token, "", err
+	}
+	secret := tokenPrefix + hex.EncodeToString(random)
+
+	token.Id, _ = uuid.GenerateUUID()
+	token.UserId = userId
+	token.Hash = hashToken(secret)
+	token.LastUsed = time.Time{}
+	token.Inserted = now
+
+	return token, secret, nil
+}
+
+// hashToken is how a token is stored and looked up. The tokens are long and random, so a plain SHA-256 is enough.
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// touchToken updates LastUsed, and says whether the token needs saving.
+func touchToken(token *types.Token, now time.Time) bool {
+	if now.Sub(token.LastUsed) < tokenLastUsedInterval {
+		return false
+	}
+	token.LastUsed = now
+	return true
+}
@@ -0,0 +1,67 @@
+package store
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/Machiel/slugify"
+
+	"internal/types"
+)
+
+var ErrUsernameUnavailable = errors.New("Unable to find a free username")
+
+// maxUserNameAttempts is how many numeric suffixes we try before giving up on a base username.
+const maxUserNameAttempts = 1000
+
+// newUserName generates a username for a brand new user from their social nickName and id. The first candidate is the
+// slug of "nickName-id", and if that is already taken we try "nickName-id-2", "nickName-id-3", and so on. The `taken`
+// func should check the unique index within the same transaction the user is created in.
+//
+// Every candidate is made to pass types.IsValidUserName() so that generated names are always ones a user could have
+// chosen themselves.
+func newUserName(nickName, id string, taken func(name string) (bool, error)) (string, error) {
+	base := slugify.Slugify(nickName + "-" + id)
+	if base == "" {
+		base = "user"
+	}
+
+	// must start with a letter
+	if base[0] < 'a' || base[0] > 'z' {
+		base = "user-" + base
+	}
+
+	for i := 1; i <= maxUserNameAttempts; i++ {
+		suffix := ""
+		if i > 1 {
+			suffix = "-" + strconv.Itoa(i)
+		}
+
+		// make room for the suffix, and don't leave a trailing dash where we chopped it
+		name := base
+		if len(name)+len(suffix) > types.UserNameMaxLength {
+			name = name[:types.UserNameMaxLength-len(suffix)]
+		}
+		name = strings.TrimRight(name, "-") + suffix
+
+		// pad out anything too short, e.g. "ab"
+		for len(name) < types.UserNameMinLength {
+			name = name + "-user"
+		}
+
+		if !types.IsValidUserName(name) {
+			continue
+		}
+
+		isTaken, err := taken(name)
+		if err != nil {
+			return "", err
+		}
+		if !isTaken {
+			return name, nil
+		}
+	}
+
+	return "", ErrUsernameUnavailable
+}
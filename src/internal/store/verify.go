@@ -0,0 +1,43 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// VerifyBoltFile opens the BoltDB file read-only and checks it looks like one of our datastores, ie. it has a schema
+// version we understand and all of the expected buckets. Use it to check a dump before relying on it.
+//
+// A datastore from before we kept a schema version (i.e. version 0, without a `meta` bucket) is fine, since Open() will
+// migrate it.
+func VerifyBoltFile(filename string) error {
+	db, err := bolt.Open(filename, 0600, &bolt.Options{Timeout: 1 * time.Second, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.View(func(tx *bolt.Tx) error {
+		version, err := getSchemaVersion(tx)
+		if err != nil {
+			return err
+		}
+		if version > SchemaVersion() {
+			return ErrSchemaTooNew
+		}
+
+		buckets := []string{userBucket, socialBucket, indexUserNameUniqueIndex}
+		if version > 0 {
+			buckets = append(buckets, metaBucket)
+		}
+		for _, name := range buckets {
+			if tx.Bucket([]byte(name)) == nil {
+				return fmt.Errorf("%s: missing bucket %q", filename, name)
+			}
+		}
+
+		return nil
+	})
+}
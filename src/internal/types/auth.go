@@ -0,0 +1,12 @@
+package types
+
+import "time"
+
+// AuthState is one attempt at logging in (or connecting an account) with a provider. Its Id is sent to the provider as
+// the OAuth state, and it only lives long enough for the user to get there and back.
+type AuthState struct {
+	Id       string // e.g. "5c3a2f1e-..." - random, and also its key in the store
+	Provider string // e.g. "twitter"
+	Expires  time.Time
+	Inserted time.Time
+}
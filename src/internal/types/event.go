@@ -0,0 +1,22 @@
+package types
+
+import "time"
+
+// The kinds of Event we record against a user.
+const (
+	EventSocialUnlinked      = "social-unlinked"
+	EventUserDeleteScheduled = "user-delete-scheduled"
+	EventUserDeleteCancelled = "user-delete-cancelled"
+	EventUserMerged          = "user-merged"
+	EventTokenCreated        = "token-created"
+	EventTokenRevoked        = "token-revoked"
+)
+
+// Event is an audit record of something which happened to a user's account.
+type Event struct {
+	Id       string // e.g. "8d0bcf5a-0a3e-4b0e-9b4e-6c1a7b1f0c0d"
+	UserId   string // e.g. "de58631b-fd37-40a4-8573-c96acd7ed22e" - the FK to our Users
+	Kind     string // e.g. "social-unlinked"
+	Detail   string // e.g. "twitter:123456"
+	Inserted time.Time
+}
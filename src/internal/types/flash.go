@@ -0,0 +1,16 @@
+package types
+
+// The kinds of Flash, which are also used as CSS classes when shown.
+const (
+	FlashSuccess = "success"
+	FlashInfo    = "info"
+	FlashWarning = "warning"
+	FlashError   = "error"
+)
+
+// Flash is a one-off message for the user, queued up in their session by one request (usually a POST) and shown on the
+// next page they see.
+type Flash struct {
+	Kind    string // e.g. "success"
+	Message string // e.g. "Your profile has been saved."
+}
@@ -0,0 +1,13 @@
+package types
+
+import "time"
+
+// Post is something the user has posted to a social provider through us, e.g. a tweet.
+type Post struct {
+	Id         string // e.g. "0b7d5d57-3c4e-4f53-9a55-1e1b0fb5ee52"
+	UserId     string // e.g. "de58631b-fd37-40a4-8573-c96acd7ed22e" - the FK to our Users
+	SocialId   string // e.g. "twitter:123456" - which account it was posted from
+	ProviderId string // e.g. "850006245121695744" - the id the provider gave the post, if any
+	Text       string // e.g. "Just setting up my Twttr."
+	Inserted   time.Time
+}
@@ -0,0 +1,20 @@
+package types
+
+import "time"
+
+// Session is one logged in browser. The cookie only holds the session's Id, so deleting the Session logs that browser
+// out no matter what else is in its cookie.
+type Session struct {
+	Id        string // e.g. "5c3a2f1e-..." - random, and also its key in the store
+	UserId    string // e.g. "de58631b-fd37-40a4-8573-c96acd7ed22e" - the FK to our Users
+	UserAgent string // e.g. "Mozilla/5.0 (X11; Linux x86_64) ..."
+	IP        string // e.g. "203.0.113.7"
+	LastSeen  time.Time
+	Inserted  time.Time
+}
+
+// Redacted returns a copy of the Session without its Id, since anyone with the Id (and our keys) could be that browser.
+func (s Session) Redacted() Session {
+	s.Id = ""
+	return s
+}
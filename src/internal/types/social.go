@@ -3,15 +3,46 @@ package types
 import "time"
 
 type Social struct {
-	Id                string // e.g. "twitter:123456"
-	UserId            string // e.g. "de58631b-fd37-40a4-8573-c96acd7ed22e" - the FK to our Users
-	Provider          string // e.g. "twitter" or "facebook" or "github"
-	NickName          string // e.g. "andychilton" - the NickName they have from the Social Provider
-	Title             string // e.g. "Andrew Chilton" - the title we got from the Social Provider
-	Email             string // e.g. "andychilton@gmail.com" - the email we got from the Social Provider
-	AccessToken       string // e.g. "deafbeef"
-	AccessTokenSecret string // e.g. "cafebabe"
-	RefreshToken      string // e.g. "baadf00d"
+	Id                string    // e.g. "twitter:123456"
+	UserId            string    // e.g. "de58631b-fd37-40a4-8573-c96acd7ed22e" - the FK to our Users
+	Provider          string    // e.g. "twitter" or "facebook" or "github"
+	NickName          string    // e.g. "andychilton" - the NickName they have from the Social Provider
+	Title             string    // e.g. "Andrew Chilton" - the title we got from the Social Provider
+	Email             string    // e.g. "andychilton@gmail.com" - the email we got from the Social Provider
+	AccessToken       string    // e.g. "deafbeef"
+	AccessTokenSecret string    // e.g. "cafebabe"
+	RefreshToken      string    // e.g. "baadf00d"
+	ExpiresAt         time.Time // when the AccessToken expires, if the provider tells us
+	AvatarURL         string    // e.g. "https://pbs.twimg.com/profile_images/..."
+	LastLogin         time.Time // the last time the user logged in with this social account
 	Inserted          time.Time
 	Updated           time.Time
 }
+
+// SocialLogIn is everything a social provider gives us when a user logs in with it. It is used both to create a new
+// Social and to refresh an existing one on re-login.
+type SocialLogIn struct {
+	Provider          string // e.g. "twitter"
+	Id                string // e.g. "123456" - the user's id at the provider (not our Social.Id)
+	NickName          string
+	Title             string
+	Email             string
+	AvatarURL         string
+	AccessToken       string
+	AccessTokenSecret string
+	RefreshToken      string
+	ExpiresAt         time.Time
+}
+
+// SocialId is the Social.Id we use internally for this provider and id, e.g. "twitter:123456".
+func (s SocialLogIn) SocialId() string {
+	return s.Provider + ":" + s.Id
+}
+
+// Redacted returns a copy of the Social with all of the secrets (tokens) removed, so it is safe to show or hand out.
+func (s Social) Redacted() Social {
+	s.AccessToken = ""
+	s.AccessTokenSecret = ""
+	s.RefreshToken = ""
+	return s
+}
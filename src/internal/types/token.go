@@ -0,0 +1,43 @@
+package types
+
+import "time"
+
+// The scopes a Token can have.
+const (
+	TokenScopeRead  = "read"  // read anything the user can see
+	TokenScopeWrite = "write" // change things on the user's behalf
+)
+
+// Token is a personal access token, which a user creates so that scripts and other tools can use the API as them. We
+// never store the token itself, only a hash of it, so the user only gets to see it once.
+type Token struct {
+	Id       string   // e.g. "0b7d5d57-3c4e-4f53-9a55-1e1b0fb5ee52"
+	UserId   string   // e.g. "de58631b-fd37-40a4-8573-c96acd7ed22e" - the FK to our Users
+	Name     string   // e.g. "Backup script" - so the user can tell them apart
+	Scopes   []string // e.g. [ "read", "write" ]
+	Hash     string   // the hex SHA-256 of the token, which is also its key in the store
+	LastUsed time.Time
+	Inserted time.Time
+}
+
+// HasScope tells you if the token has been given this scope.
+func (t Token) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Redacted returns a copy of the Token without its Hash, so it is safe to show or hand out.
+func (t Token) Redacted() Token {
+	t.Hash = ""
+	return t
+}
+
+// NewToken is what the user fills in to create a Token.
+type NewToken struct {
+	Name   string   `schema:"name" valid:"required~Please give the token a name,length(1|64)~The name must be at most 64 characters long"`
+	Scopes []string `schema:"scopes" valid:"required~Please choose at least one scope,matches(^(read|write)$)~Unknown scope"`
+}
@@ -1,21 +1,44 @@
 package types
 
-import "time"
+import (
+	"regexp"
+	"time"
+)
+
+// These mirror the `valid` tag on UpdateUser.Name so that any username we generate ourselves could also have been
+// picked by the user.
+const (
+	UserNameMinLength = 3
+	UserNameMaxLength = 32
+)
+
+var userNameRegexp = regexp.MustCompile(`^[a-z][a-z0-9-]+[a-z0-9]$`)
 
 type User struct {
-	Id        string   // e.g. "de58631b-fd37-40a4-8573-c96acd7ed22e"
-	Name      string   // e.g. "chilts" (unique)
-	Title     string   // e.g. "Andrew Chilton"
-	Email     string   // e.g. "andychilton@gmail.com"
-	SocialIds []string // e.g. [ "twitter:123456", "facebook:123" ]
-	Inserted  time.Time
-	Updated   time.Time
+	Id          string    // e.g. "de58631b-fd37-40a4-8573-c96acd7ed22e"
+	Name        string    // e.g. "chilts" (unique)
+	Title       string    // e.g. "Andrew Chilton"
+	Email       string    // e.g. "andychilton@gmail.com"
+	SocialIds   []string  // e.g. [ "twitter:123456", "facebook:123" ]
+	DeleteAfter time.Time // if set, the user has asked for their account to be deleted and it will be purged after this
+	Inserted    time.Time
+	Updated     time.Time
 }
 
+// UpdateUser is what the user may change about themselves. The text after each `~` in the `valid` tags is shown to the
+// user when that check fails (note: these messages can't contain a comma).
 type UpdateUser struct {
-	Name  string `schema:"userName" valid:"required,length(3|32),matches(^[a-z][a-z0-9-]+[a-z0-9]$)"`
-	Title string `schema:"title" valid:"required"`
-	Email string `schema:"email" valid:"required,email"`
+	Name  string `schema:"userName" valid:"required~Please choose a username,length(3|32)~Your username must be between 3 and 32 characters long,matches(^[a-z][a-z0-9-]+[a-z0-9]$)~Use lowercase letters and numbers and dashes only - starting with a letter and not ending with a dash"`
+	Title string `schema:"title" valid:"required~Please enter your name"`
+	Email string `schema:"email" valid:"required~Please enter your email address,email~That doesn't look like an email address"`
+}
+
+// MergeUser says which details to keep when merging two users. Each field must match the same field of one of the two
+// users being merged.
+type MergeUser struct {
+	Name  string `schema:"userName"`
+	Title string `schema:"title"`
+	Email string `schema:"email"`
 }
 
 // Validate firstly normalises the thing, then validates it and returns either true (valid) or false (invalid). It sets any messages onto
@@ -27,7 +50,14 @@ func (x *User) Validate() bool {
 	x.Inserted = now
 	x.Updated = now
 
-	// ToDo: check Name contains only 'a-z0-9-', min 3 chars, max 32, starts with a letter.
+	return IsValidUserName(x.Name)
+}
 
-	return true
+// IsValidUserName checks the name contains only 'a-z0-9-', is between 3 and 32 chars, starts with a letter and doesn't
+// end with a dash.
+func IsValidUserName(name string) bool {
+	if len(name) < UserNameMinLength || len(name) > UserNameMaxLength {
+		return false
+	}
+	return userNameRegexp.MatchString(name)
 }